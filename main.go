@@ -4,14 +4,33 @@ import (
 	"NMS/src/server"
 	"NMS/src/util"
 	"fmt"
+	"os"
 )
 
 /*
 * main function
-*  starts the ZeroMQ server.
+*  starts the ZeroMQ server, or with the keygen argument prints a new CURVE key pair for the security configuration.
  */
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+
+		public, secret, err := server.GenerateCurveKeyPair()
+
+		if err != nil {
+
+			fmt.Fprintln(os.Stderr, "Failed to generate key pair:", err)
+
+			os.Exit(1)
+
+		}
+
+		fmt.Printf("publicKey: %s\nsecretKey: %s\n", public, secret)
+
+		return
+
+	}
+
 	fmt.Println("🚀 Server started")
 
 	logger := util.InitializeLogger()
@@ -0,0 +1,393 @@
+package alerting
+
+import (
+	"NMS/src/util"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+const (
+	SeverityOK       = "ok"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var (
+	logInstance = util.InitializeLogger()
+
+	severityLevels = map[string]int{SeverityOK: 0, SeverityWarning: 1, SeverityCritical: 2}
+)
+
+/*
+Target identifies what a polling result measures. Several targets can share a host, such as the HTTP checks of
+different URLs or the certificates served on different ports or names, and each has its own threshold state.
+*/
+type Target struct {
+	IP         string
+	URL        string
+	Port       int
+	ServerName string
+}
+
+/*
+key returns the identity of the target: its URL, else its ip with the port and server name when set.
+*/
+func (t Target) key() string {
+
+	if t.URL != "" {
+
+		return t.URL
+
+	}
+
+	key := t.IP
+
+	if t.Port > 0 {
+
+		key += ":" + strconv.Itoa(t.Port)
+
+	}
+
+	if t.ServerName != "" && t.ServerName != t.IP {
+
+		key += "/" + t.ServerName
+
+	}
+
+	return key
+
+}
+
+/*
+thresholdState is the evaluation state of a threshold for one target.
+pending counts the consecutive breaches of pendingSeverity that have not raised an alert yet.
+*/
+type thresholdState struct {
+	severity        string
+	pendingSeverity string
+	pending         int
+}
+
+/*
+Evaluator evaluates the configured thresholds against polling results and publishes alert and clear events.
+*/
+type Evaluator struct {
+	mutex      sync.Mutex
+	thresholds []util.Threshold
+	states     map[string]*thresholdState
+}
+
+var (
+	evaluatorOnce sync.Once
+	evaluator     *Evaluator
+)
+
+/*
+NewEvaluator returns an Evaluator for thresholds.
+*/
+func NewEvaluator(thresholds []util.Threshold) *Evaluator {
+
+	return &Evaluator{thresholds: thresholds, states: make(map[string]*thresholdState)}
+
+}
+
+/*
+DefaultEvaluator returns the Evaluator for the thresholds of the engine configuration.
+*/
+func DefaultEvaluator() *Evaluator {
+
+	evaluatorOnce.Do(func() {
+
+		evaluator = NewEvaluator(util.LoadConfig().Thresholds)
+
+	})
+
+	return evaluator
+
+}
+
+/*
+Evaluate checks the thresholds matching systemType against the metrics in result for target.
+
+Parameters:
+- target: The polled target.
+- systemType: The system type of the polling result.
+- result: The metrics of the polling result.
+*/
+func (e *Evaluator) Evaluate(target Target, systemType string, result map[string]interface{}) {
+
+	e.mutex.Lock()
+
+	defer e.mutex.Unlock()
+
+	for index, threshold := range e.thresholds {
+
+		if threshold.SystemType != "" && threshold.SystemType != systemType {
+
+			continue
+
+		}
+
+		value, ok := toFloat(result[threshold.Metric])
+
+		if !ok {
+
+			continue
+
+		}
+
+		key := fmt.Sprintf("%s|%s|%d", systemType, target.key(), index)
+
+		state, exists := e.states[key]
+
+		if !exists {
+
+			state = &thresholdState{severity: SeverityOK}
+
+			e.states[key] = state
+
+		}
+
+		e.transition(target, systemType, threshold, state, value)
+
+	}
+
+}
+
+/*
+transition moves the state of a threshold according to a new value and publishes the resulting events.
+Escalations wait for the configured number of consecutive breaches; de-escalations apply immediately,
+hysteresis already protecting them from flapping.
+*/
+func (e *Evaluator) transition(target Target, systemType string, threshold util.Threshold, state *thresholdState, value float64) {
+
+	severity := breachedSeverity(threshold, state.severity, value)
+
+	if severityLevels[severity] <= severityLevels[state.severity] {
+
+		state.pending = 0
+
+		state.pendingSeverity = ""
+
+		if severity != state.severity {
+
+			previous := state.severity
+
+			state.severity = severity
+
+			e.publish(target, systemType, threshold, severity, previous, value)
+
+		}
+
+		return
+
+	}
+
+	if state.pendingSeverity != severity {
+
+		state.pendingSeverity = severity
+
+		state.pending = 0
+
+	}
+
+	state.pending++
+
+	consecutive := threshold.Consecutive
+
+	if consecutive < 1 {
+
+		consecutive = 1
+
+	}
+
+	if state.pending >= consecutive {
+
+		previous := state.severity
+
+		state.severity = severity
+
+		state.pending = 0
+
+		state.pendingSeverity = ""
+
+		e.publish(target, systemType, threshold, severity, previous, value)
+
+	}
+
+}
+
+func (e *Evaluator) publish(target Target, systemType string, threshold util.Threshold, severity string, previous string, value float64) {
+
+	event := map[string]interface{}{
+
+		"ip": target.IP,
+
+		"target": target.key(),
+
+		"SystemType": systemType,
+
+		"metric": threshold.Metric,
+
+		"value": value,
+
+		"comparison": comparison(threshold),
+
+		"severity": severity,
+
+		"previousSeverity": previous,
+	}
+
+	eventType := util.EventTypeAlert
+
+	limit := limitFor(threshold, severity)
+
+	if severity == SeverityOK {
+
+		eventType = util.EventTypeClear
+
+		limit = limitFor(threshold, previous)
+
+	}
+
+	if limit != nil {
+
+		event["threshold"] = *limit
+
+	}
+
+	logInstance.LogInfo(fmt.Sprintf("Threshold %s for %s on %s: %s -> %s (value %v)", eventType, threshold.Metric, target.key(), previous, severity, value))
+
+	util.PublishEvent(eventType, event)
+
+}
+
+/*
+breachedSeverity returns the highest severity whose limit value breaches. A severity the threshold is already at
+stays breached until the value is back past its limit by the hysteresis.
+*/
+func breachedSeverity(threshold util.Threshold, current string, value float64) string {
+
+	for _, severity := range []string{SeverityCritical, SeverityWarning} {
+
+		limit := limitFor(threshold, severity)
+
+		if limit == nil {
+
+			continue
+
+		}
+
+		hysteresis := 0.0
+
+		if severityLevels[current] >= severityLevels[severity] {
+
+			hysteresis = threshold.Hysteresis
+
+		}
+
+		if breaches(comparison(threshold), value, *limit, hysteresis) {
+
+			return severity
+
+		}
+
+	}
+
+	return SeverityOK
+
+}
+
+/*
+breaches compares value to limit, moving the limit towards the clear side by hysteresis.
+*/
+func breaches(operator string, value float64, limit float64, hysteresis float64) bool {
+
+	switch operator {
+
+	case ">":
+
+		return value > limit-hysteresis
+
+	case ">=":
+
+		return value >= limit-hysteresis
+
+	case "<":
+
+		return value < limit+hysteresis
+
+	case "<=":
+
+		return value <= limit+hysteresis
+
+	case "==":
+
+		return value == limit
+
+	case "!=":
+
+		return value != limit
+
+	default:
+
+		return false
+
+	}
+
+}
+
+func comparison(threshold util.Threshold) string {
+
+	if threshold.Comparison == "" {
+
+		return ">"
+
+	}
+
+	return threshold.Comparison
+
+}
+
+func limitFor(threshold util.Threshold, severity string) *float64 {
+
+	switch severity {
+
+	case SeverityCritical:
+
+		return threshold.Critical
+
+	case SeverityWarning:
+
+		return threshold.Warning
+
+	default:
+
+		return nil
+
+	}
+
+}
+
+func toFloat(value interface{}) (float64, bool) {
+
+	switch number := value.(type) {
+
+	case int64:
+
+		return float64(number), true
+
+	case float64:
+
+		return number, true
+
+	case int:
+
+		return float64(number), true
+
+	}
+
+	return 0, false
+
+}
@@ -0,0 +1,86 @@
+package availability
+
+import (
+	"NMS/src/breaker"
+	"encoding/json"
+	"time"
+)
+
+/*
+HandleStatus processes a status request and returns the availability of the tracked hosts.
+
+Parameters:
+- responseData: The request map. It may include:
+  - ip: Restrict the report to one host.
+  - probe: When true, probe the host on port (or the default port of SystemType) before reporting.
+
+Returns:
+- A JSON string with the state, last state change and availability percentage of the hosts, and the circuit
+breaker state of the hosts that have been failing.
+*/
+func HandleStatus(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	ip, _ := responseData["ip"].(string)
+
+	if probe, _ := responseData["probe"].(bool); probe {
+
+		systemType, _ := responseData["SystemType"].(string)
+
+		port := DefaultProbePorts[systemType]
+
+		if value, ok := responseData["port"].(float64); ok {
+
+			port = int(value)
+
+		}
+
+		if ip == "" || port <= 0 {
+
+			errorData["probe_error"] = "Probe requires ip and a port or known SystemType"
+
+			responseData["errors"] = errorData
+
+			responseData["status"] = "fail"
+
+			jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+			return string(jsonResponse)
+
+		}
+
+		state, err := Probe(ip, port)
+
+		if err != nil {
+
+			errorData["probe_error"] = err.Error()
+
+			responseData["errors"] = errorData
+
+		}
+
+		DefaultTracker().Record(ip, state, time.Now())
+
+	}
+
+	responseData["result"] = map[string]interface{}{
+
+		"hosts": DefaultTracker().Status(ip),
+
+		"circuits": breaker.Default().Hosts(ip),
+	}
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
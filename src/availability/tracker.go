@@ -0,0 +1,334 @@
+package availability
+
+import (
+	"NMS/src/util"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	StateUnknown     = "unknown"
+	StateUp          = "up"
+	StateDown        = "down"
+	StateUnreachable = "unreachable"
+	probeTimeout     = 3 * time.Second
+)
+
+var (
+	logInstance = util.InitializeLogger()
+
+	// DefaultProbePorts maps a system type to the TCP port probed when its port is not given in the request.
+	DefaultProbePorts = map[string]int{
+		"windows":    5985,
+		"postgresql": 5432,
+		"mysql":      3306,
+	}
+)
+
+/*
+HostStatus is the availability of a host as reported by the status request.
+AvailabilityPercent is the share of the tracked time the host was up.
+*/
+type HostStatus struct {
+	IP                  string    `json:"ip"`
+	State               string    `json:"state"`
+	PreviousState       string    `json:"previousState,omitempty"`
+	LastStateChange     time.Time `json:"lastStateChange"`
+	LastCheck           time.Time `json:"lastCheck"`
+	AvailabilityPercent float64   `json:"availabilityPercent"`
+	Checks              int64     `json:"checks"`
+}
+
+type hostRecord struct {
+	status  HostStatus
+	tracked time.Duration
+	up      time.Duration
+}
+
+/*
+Tracker records the up/down/unreachable state of hosts and publishes an event on every state change.
+probing holds the hosts whose failure is being confirmed by a probe.
+*/
+type Tracker struct {
+	mutex   sync.Mutex
+	hosts   map[string]*hostRecord
+	probing map[string]bool
+}
+
+var defaultTracker = NewTracker()
+
+/*
+NewTracker returns an empty Tracker.
+*/
+func NewTracker() *Tracker {
+
+	return &Tracker{hosts: make(map[string]*hostRecord), probing: make(map[string]bool)}
+
+}
+
+/*
+DefaultTracker returns the Tracker shared by the engine.
+*/
+func DefaultTracker() *Tracker {
+
+	return defaultTracker
+
+}
+
+/*
+Record stores the state of a host observed at now. The time since the previous observation is accounted to the
+previous state, and a state_change event is published when the state differs from it. An observation older than
+the last one, such as a probe that finished after a later request, is ignored.
+*/
+func (t *Tracker) Record(ip string, state string, now time.Time) {
+
+	t.mutex.Lock()
+
+	current, exists := t.hosts[ip]
+
+	if !exists {
+
+		current = &hostRecord{status: HostStatus{IP: ip, State: StateUnknown, LastStateChange: now, LastCheck: now}}
+
+		t.hosts[ip] = current
+
+	}
+
+	if now.Before(current.status.LastCheck) {
+
+		t.mutex.Unlock()
+
+		return
+
+	}
+
+	if current.status.State != StateUnknown {
+
+		elapsed := now.Sub(current.status.LastCheck)
+
+		current.tracked += elapsed
+
+		if current.status.State == StateUp {
+
+			current.up += elapsed
+
+		}
+
+	}
+
+	current.status.LastCheck = now
+
+	current.status.Checks++
+
+	changed := current.status.State != state
+
+	previous := current.status.State
+
+	if changed {
+
+		current.status.PreviousState = previous
+
+		current.status.State = state
+
+		current.status.LastStateChange = now
+
+	}
+
+	t.mutex.Unlock()
+
+	if changed {
+
+		logInstance.LogInfo(fmt.Sprintf("Host %s changed state: %s -> %s", ip, previous, state))
+
+		util.PublishEvent(util.EventTypeStateChange, map[string]interface{}{
+
+			"ip": ip,
+
+			"state": state,
+
+			"previousState": previous,
+		})
+
+	}
+
+}
+
+/*
+Status returns the availability of the tracked hosts, or of ip only when it is not empty.
+*/
+func (t *Tracker) Status(ip string) []HostStatus {
+
+	t.mutex.Lock()
+
+	defer t.mutex.Unlock()
+
+	statuses := make([]HostStatus, 0, len(t.hosts))
+
+	for host, current := range t.hosts {
+
+		if ip != "" && host != ip {
+
+			continue
+
+		}
+
+		status := current.status
+
+		tracked, up := current.tracked, current.up
+
+		// account the time since the last check to the current state
+		if status.State != StateUnknown {
+
+			elapsed := time.Since(status.LastCheck)
+
+			tracked += elapsed
+
+			if status.State == StateUp {
+
+				up += elapsed
+
+			}
+
+		}
+
+		if tracked > 0 {
+
+			status.AvailabilityPercent = float64(int64(float64(up)/float64(tracked)*10000)) / 100
+
+		} else if status.State == StateUp {
+
+			status.AvailabilityPercent = 100
+
+		}
+
+		statuses = append(statuses, status)
+
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].IP < statuses[j].IP })
+
+	return statuses
+
+}
+
+/*
+Probe checks whether a host accepts TCP connections on port.
+
+Returns:
+- StateUp if the connection succeeded.
+- StateUnreachable if there is no route to the host or it could not be resolved, and StateDown otherwise.
+- The probe error, if any.
+*/
+func Probe(ip string, port int) (string, error) {
+
+	connection, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), probeTimeout)
+
+	if err == nil {
+
+		connection.Close()
+
+		return StateUp, nil
+
+	}
+
+	var dnsError *net.DNSError
+
+	if errors.As(err, &dnsError) || errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+
+		return StateUnreachable, err
+
+	}
+
+	return StateDown, err
+
+}
+
+/*
+RecordPollOutcome updates the state of a host from the outcome of a request that targeted it.
+A successful request marks the host up. A failure to reach the host, i.e. of a transport error class, is confirmed
+by probing the host on port, so that a host that is reachable but whose service failed is still reported up.
+The probe runs in the background, one at a time per host, and its state counts as observed at the failure.
+Without a port to probe, as for the ping system type, it marks the host down, or unreachable. Other failures,
+such as wrong credentials or invalid input, say nothing about the host and leave its state unchanged.
+*/
+func (t *Tracker) RecordPollOutcome(ip string, systemType string, port int, success bool, errorClass string) {
+
+	if success {
+
+		t.Record(ip, StateUp, time.Now())
+
+		return
+
+	}
+
+	if !util.IsTransportError(errorClass) {
+
+		return
+
+	}
+
+	if port <= 0 {
+
+		port = DefaultProbePorts[systemType]
+
+	}
+
+	if port <= 0 {
+
+		state := StateDown
+
+		if errorClass == util.ErrorClassUnreachable {
+
+			state = StateUnreachable
+
+		}
+
+		t.Record(ip, state, time.Now())
+
+		return
+
+	}
+
+	observed := time.Now()
+
+	t.mutex.Lock()
+
+	if t.probing[ip] {
+
+		t.mutex.Unlock()
+
+		return
+
+	}
+
+	t.probing[ip] = true
+
+	t.mutex.Unlock()
+
+	go func() {
+
+		state, err := Probe(ip, port)
+
+		if err != nil {
+
+			logInstance.LogInfo(fmt.Sprintf("Probe of %s:%d failed: %v", ip, port, err))
+
+		}
+
+		t.mutex.Lock()
+
+		delete(t.probing, ip)
+
+		t.mutex.Unlock()
+
+		t.Record(ip, state, observed)
+
+	}()
+
+}
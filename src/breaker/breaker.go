@@ -0,0 +1,410 @@
+package breaker
+
+import (
+	"NMS/src/util"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed             = "closed"
+	StateOpen               = "open"
+	StateHalfOpen           = "half-open"
+	DefaultFailureThreshold = 5
+	DefaultOpenDuration     = 60 * time.Second
+)
+
+var (
+	logInstance = util.InitializeLogger()
+
+	// ErrCircuitOpen is returned for requests to a host whose circuit is open.
+	ErrCircuitOpen = errors.New("circuit open")
+
+	// DefaultFailOn lists the error classes that count as failures of the host when the configuration sets none.
+	DefaultFailOn = []string{util.ErrorClassTimeout, util.ErrorClassConnectionRefused, util.ErrorClassConnectionReset, util.ErrorClassUnreachable}
+)
+
+/*
+HostState is the circuit of a service of a host as reported in the health and status responses.
+NextProbe is when the next request will be let through to probe a service whose circuit is open.
+*/
+type HostState struct {
+	IP                  string     `json:"ip"`
+	Service             string     `json:"service"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastErrorClass      string     `json:"lastErrorClass,omitempty"`
+	OpenedAt            *time.Time `json:"openedAt,omitempty"`
+	NextProbe           *time.Time `json:"nextProbe,omitempty"`
+	Trips               uint64     `json:"trips"`
+	Rejected            uint64     `json:"rejected"`
+}
+
+/*
+Stats reports the circuits of the services that have failed at least once.
+*/
+type Stats struct {
+	FailureThreshold int         `json:"failureThreshold"`
+	OpenSeconds      float64     `json:"openSeconds"`
+	Open             int         `json:"open"`
+	HalfOpen         int         `json:"halfOpen"`
+	Hosts            []HostState `json:"hosts"`
+}
+
+// circuitKey identifies a circuit: a host may answer on one service, e.g. WinRM, while another one is down.
+type circuitKey struct {
+	host    string
+	service string
+}
+
+type circuit struct {
+	state     string
+	failures  int
+	lastClass string
+	openedAt  time.Time
+	probing   bool
+	trips     uint64
+	rejected  uint64
+}
+
+/*
+Breaker keeps one circuit per service of a host, such as a SystemType and port. A circuit opens after a number of
+consecutive failures of the service, and requests to it then fail at once instead of tying up a worker until they time out. Once the open duration has
+passed, the circuit is half-open: one probe request is let through while the others keep failing, and the outcome
+of the probe closes or reopens the circuit.
+*/
+type Breaker struct {
+	threshold int
+	openFor   time.Duration
+	failOn    map[string]bool
+	mutex     sync.Mutex
+	circuits  map[circuitKey]*circuit
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultBreaker *Breaker
+)
+
+/*
+NewBreaker returns a Breaker with the settings of config.
+*/
+func NewBreaker(config util.CircuitBreakerConfig) *Breaker {
+
+	breaker := &Breaker{
+
+		threshold: config.FailureThreshold,
+
+		openFor: time.Duration(config.OpenSeconds) * time.Second,
+
+		failOn: make(map[string]bool),
+
+		circuits: make(map[circuitKey]*circuit),
+	}
+
+	if breaker.threshold <= 0 {
+
+		breaker.threshold = DefaultFailureThreshold
+
+	}
+
+	if breaker.openFor <= 0 {
+
+		breaker.openFor = DefaultOpenDuration
+
+	}
+
+	failOn := config.FailOn
+
+	if len(failOn) == 0 {
+
+		failOn = DefaultFailOn
+
+	}
+
+	for _, class := range failOn {
+
+		breaker.failOn[class] = true
+
+	}
+
+	return breaker
+
+}
+
+/*
+Default returns the Breaker shared by the engine, configured from the circuitBreaker section of the engine configuration.
+*/
+func Default() *Breaker {
+
+	defaultOnce.Do(func() {
+
+		defaultBreaker = NewBreaker(util.LoadConfig().CircuitBreaker)
+
+	})
+
+	return defaultBreaker
+
+}
+
+/*
+Allow reports whether a request may be sent to service of host.
+
+Returns:
+  - A function to call once with the outcome of the request, after any retries. A failure of a class the breaker
+    counts adds to the failures of the service; a success, or a failure of another class (the service answered),
+    closes the circuit; a failure without class, such as invalid input, or of the engine itself leaves it unchanged.
+  - An error wrapping ErrCircuitOpen if the circuit is open, or half-open with a probe in flight.
+*/
+func (b *Breaker) Allow(host string, service string) (func(success bool, errorClass string), error) {
+
+	b.mutex.Lock()
+
+	defer b.mutex.Unlock()
+
+	key := circuitKey{host: host, service: service}
+
+	current, exists := b.circuits[key]
+
+	if !exists {
+
+		current = &circuit{state: StateClosed}
+
+		b.circuits[key] = current
+
+	}
+
+	probe := false
+
+	switch current.state {
+
+	case StateOpen:
+
+		if wait := b.openFor - time.Since(current.openedAt); wait > 0 {
+
+			current.rejected++
+
+			return nil, fmt.Errorf("%w: %s of host %s failed %d consecutive times (%s), next probe in %v",
+				ErrCircuitOpen, service, host, current.failures, current.lastClass, wait.Round(time.Second))
+
+		}
+
+		current.state = StateHalfOpen
+
+		logInstance.LogInfo(fmt.Sprintf("Circuit of %s of host %s is half-open, probing", service, host))
+
+		probe = true
+
+	case StateHalfOpen:
+
+		if current.probing {
+
+			current.rejected++
+
+			return nil, fmt.Errorf("%w: %s of host %s is being probed", ErrCircuitOpen, service, host)
+
+		}
+
+		probe = true
+
+	}
+
+	current.probing = current.probing || probe
+
+	done := func(success bool, errorClass string) {
+
+		b.record(key, probe, success, errorClass)
+
+	}
+
+	return done, nil
+
+}
+
+/*
+record applies the outcome of a request to the circuit of key.
+*/
+func (b *Breaker) record(key circuitKey, probe bool, success bool, errorClass string) {
+
+	b.mutex.Lock()
+
+	current := b.circuits[key]
+
+	if probe {
+
+		current.probing = false
+
+	}
+
+	previous := current.state
+
+	switch {
+
+	case !success && b.failOn[errorClass]:
+
+		current.failures++
+
+		current.lastClass = errorClass
+
+		if current.state == StateHalfOpen || (current.state == StateClosed && current.failures >= b.threshold) {
+
+			current.state = StateOpen
+
+			current.openedAt = time.Now()
+
+			current.trips++
+
+		}
+
+	case success || (errorClass != "" && errorClass != util.ErrorClassLocal):
+
+		current.failures = 0
+
+		current.state = StateClosed
+
+	}
+
+	state, failures := current.state, current.failures
+
+	b.mutex.Unlock()
+
+	if state == previous {
+
+		return
+
+	}
+
+	switch state {
+
+	case StateOpen:
+
+		logInstance.LogWarning(fmt.Sprintf("Circuit of %s of host %s opened after %d consecutive failures (%s), failing requests for %v",
+			key.service, key.host, failures, errorClass, b.openFor))
+
+	case StateClosed:
+
+		logInstance.LogInfo(fmt.Sprintf("Circuit of %s of host %s closed", key.service, key.host))
+
+	}
+
+	util.PublishEvent(util.EventTypeCircuitChange, map[string]interface{}{
+
+		"ip": key.host,
+
+		"service": key.service,
+
+		"state": state,
+
+		"previousState": previous,
+	})
+
+}
+
+/*
+Hosts returns the circuits that have failed since their last success, or all the circuits of ip when it is not empty.
+*/
+func (b *Breaker) Hosts(ip string) []HostState {
+
+	b.mutex.Lock()
+
+	defer b.mutex.Unlock()
+
+	hosts := make([]HostState, 0)
+
+	for key, current := range b.circuits {
+
+		if (ip != "" && key.host != ip) || (ip == "" && current.state == StateClosed && current.failures == 0) {
+
+			continue
+
+		}
+
+		state := HostState{
+
+			IP: key.host,
+
+			Service: key.service,
+
+			State: current.state,
+
+			ConsecutiveFailures: current.failures,
+
+			LastErrorClass: current.lastClass,
+
+			Trips: current.trips,
+
+			Rejected: current.rejected,
+		}
+
+		if current.state != StateClosed {
+
+			openedAt := current.openedAt
+
+			state.OpenedAt = &openedAt
+
+		}
+
+		if current.state == StateOpen {
+
+			nextProbe := current.openedAt.Add(b.openFor)
+
+			state.NextProbe = &nextProbe
+
+		}
+
+		hosts = append(hosts, state)
+
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+
+		if hosts[i].IP != hosts[j].IP {
+
+			return hosts[i].IP < hosts[j].IP
+
+		}
+
+		return hosts[i].Service < hosts[j].Service
+
+	})
+
+	return hosts
+
+}
+
+/*
+Stats returns a snapshot of the breaker state.
+*/
+func (b *Breaker) Stats() Stats {
+
+	stats := Stats{
+
+		FailureThreshold: b.threshold,
+
+		OpenSeconds: b.openFor.Seconds(),
+
+		Hosts: b.Hosts(""),
+	}
+
+	for _, host := range stats.Hosts {
+
+		switch host.State {
+
+		case StateOpen:
+
+			stats.Open++
+
+		case StateHalfOpen:
+
+			stats.HalfOpen++
+
+		}
+
+	}
+
+	return stats
+
+}
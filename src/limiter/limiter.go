@@ -0,0 +1,329 @@
+package limiter
+
+import (
+	"NMS/src/util"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultMaxPerHost = 2
+	DefaultDeadline   = 60 * time.Second
+)
+
+var (
+	logInstance = util.InitializeLogger()
+
+	// ErrDeadlineExceeded is returned when a request could not get a slot or a rate token before its deadline.
+	ErrDeadlineExceeded = errors.New("deadline exceeded while waiting for limits")
+)
+
+/*
+Stats reports the state of the limiter in the health response.
+*/
+type Stats struct {
+	MaxPerHost int            `json:"maxPerHost"`
+	HostsBusy  map[string]int `json:"hostsBusy"`
+	Waiting    int            `json:"waiting"`
+	Admitted   uint64         `json:"admitted"`
+	Rejected   uint64         `json:"rejected"`
+}
+
+/*
+Limiter caps the number of concurrent requests per target host and the rate of requests overall and per plugin.
+Requests wait for a slot and a token until their deadline instead of failing at once.
+*/
+type Limiter struct {
+	maxPerHost int
+	global     *rate.Limiter
+	plugins    map[string]*rate.Limiter
+	mutex      sync.Mutex
+	hosts      map[string]chan struct{}
+	waiting    int
+	admitted   uint64
+	rejected   uint64
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultLimiter *Limiter
+)
+
+/*
+NewLimiter returns a Limiter for the limits of config. A rate of 0 leaves the rate unlimited.
+*/
+func NewLimiter(config util.LimitsConfig) *Limiter {
+
+	limiter := &Limiter{
+
+		maxPerHost: config.MaxPerHost,
+
+		plugins: make(map[string]*rate.Limiter),
+
+		hosts: make(map[string]chan struct{}),
+	}
+
+	if limiter.maxPerHost <= 0 {
+
+		limiter.maxPerHost = DefaultMaxPerHost
+
+	}
+
+	limiter.global = newRateLimiter(config.GlobalRate)
+
+	for systemType, limit := range config.PluginRates {
+
+		limiter.plugins[systemType] = newRateLimiter(limit)
+
+	}
+
+	return limiter
+
+}
+
+func newRateLimiter(limit util.RateLimit) *rate.Limiter {
+
+	if limit.Rate <= 0 {
+
+		return nil
+
+	}
+
+	burst := limit.Burst
+
+	if burst <= 0 {
+
+		burst = 1
+
+	}
+
+	return rate.NewLimiter(rate.Limit(limit.Rate), burst)
+
+}
+
+/*
+Default returns the Limiter shared by the engine, configured from the limits of the engine configuration.
+*/
+func Default() *Limiter {
+
+	defaultOnce.Do(func() {
+
+		defaultLimiter = NewLimiter(util.LoadConfig().Limits)
+
+	})
+
+	return defaultLimiter
+
+}
+
+/*
+Deadline returns how long a request may wait for its limits: deadlineMs of the request, or the configured default.
+*/
+func Deadline(responseData map[string]interface{}) time.Duration {
+
+	if value, ok := responseData["deadlineMs"].(float64); ok && value > 0 {
+
+		return time.Duration(value) * time.Millisecond
+
+	}
+
+	if value := util.LoadConfig().Limits.DeadlineMs; value > 0 {
+
+		return time.Duration(value) * time.Millisecond
+
+	}
+
+	return DefaultDeadline
+
+}
+
+/*
+Host returns the host a request targets for the per-host limit: its ip, or the host of its url, as for the
+HTTP checks. It is empty when the request names no target.
+*/
+func Host(responseData map[string]interface{}) string {
+
+	if ip, _ := responseData["ip"].(string); ip != "" {
+
+		return ip
+
+	}
+
+	if rawURL, _ := responseData["url"].(string); rawURL != "" {
+
+		if parsed, err := url.Parse(rawURL); err == nil {
+
+			return parsed.Hostname()
+
+		}
+
+	}
+
+	return ""
+
+}
+
+/*
+Acquire waits until host has a free slot and the global and plugin rates allow another request.
+An empty host has no per-host limit, so that requests without a target do not share one set of slots.
+
+Returns:
+- A function that releases the slot of host once the request is done.
+- An error wrapping ErrDeadlineExceeded if ctx ends before the request is admitted.
+*/
+func (l *Limiter) Acquire(ctx context.Context, host string, systemType string) (func(), error) {
+
+	started := time.Now()
+
+	l.mutex.Lock()
+
+	var slots chan struct{}
+
+	if host != "" {
+
+		var exists bool
+
+		if slots, exists = l.hosts[host]; !exists {
+
+			slots = make(chan struct{}, l.maxPerHost)
+
+			l.hosts[host] = slots
+
+		}
+
+	}
+
+	l.waiting++
+
+	l.mutex.Unlock()
+
+	admitted := false
+
+	defer func() {
+
+		l.mutex.Lock()
+
+		l.waiting--
+
+		if admitted {
+
+			l.admitted++
+
+		} else {
+
+			l.rejected++
+
+		}
+
+		l.mutex.Unlock()
+
+	}()
+
+	release := func() {}
+
+	if slots != nil {
+
+		select {
+
+		case slots <- struct{}{}:
+
+		default:
+
+			select {
+
+			case slots <- struct{}{}:
+
+			case <-ctx.Done():
+
+				return nil, fmt.Errorf("%w: no free slot on host %s after %v (%d concurrent requests allowed)",
+					ErrDeadlineExceeded, host, time.Since(started).Round(time.Millisecond), l.maxPerHost)
+
+			}
+
+		}
+
+		release = func() { <-slots }
+
+	}
+
+	limits := []struct {
+		name    string
+		limiter *rate.Limiter
+	}{
+		{"global", l.global},
+		{"plugin " + systemType, l.plugins[systemType]},
+	}
+
+	for _, limit := range limits {
+
+		if limit.limiter == nil {
+
+			continue
+
+		}
+
+		// Wait fails at once when the next token would only be available after the deadline
+		if err := limit.limiter.Wait(ctx); err != nil {
+
+			release()
+
+			return nil, fmt.Errorf("%w: %s rate limit of %v requests/s would delay the request beyond its deadline",
+				ErrDeadlineExceeded, limit.name, limit.limiter.Limit())
+
+		}
+
+	}
+
+	if waited := time.Since(started); waited > time.Second {
+
+		logInstance.LogInfo(fmt.Sprintf("Request for %s (%s) waited %v for its limits", host, systemType, waited.Round(time.Millisecond)))
+
+	}
+
+	admitted = true
+
+	return release, nil
+
+}
+
+/*
+Stats returns a snapshot of the limiter state.
+*/
+func (l *Limiter) Stats() Stats {
+
+	l.mutex.Lock()
+
+	defer l.mutex.Unlock()
+
+	stats := Stats{
+
+		MaxPerHost: l.maxPerHost,
+
+		HostsBusy: make(map[string]int),
+
+		Waiting: l.waiting,
+
+		Admitted: l.admitted,
+
+		Rejected: l.rejected,
+	}
+
+	for host, slots := range l.hosts {
+
+		if busy := len(slots); busy > 0 {
+
+			stats.HostsBusy[host] = busy
+
+		}
+
+	}
+
+	return stats
+
+}
@@ -0,0 +1,493 @@
+package certificate
+
+import (
+	"NMS/src/util"
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SystemTypeCertificate = "certificate"
+	DefaultPort           = 443
+	DefaultTimeout        = 10000 // milliseconds
+	StartTLSSMTP          = "smtp"
+	StartTLSIMAP          = "imap"
+	StartTLSPOP3          = "pop3"
+)
+
+var logInstance = util.InitializeLogger()
+
+/*
+startTLS upgrades a plain-text connection with the STARTTLS command of protocol.
+*/
+func startTLS(connection net.Conn, protocol string) error {
+
+	reader := bufio.NewReader(connection)
+
+	switch protocol {
+
+	case StartTLSSMTP:
+
+		if _, err := readSMTPReply(reader, "220"); err != nil {
+
+			return err
+
+		}
+
+		if _, err := fmt.Fprintf(connection, "EHLO pluginengine\r\n"); err != nil {
+
+			return err
+
+		}
+
+		if _, err := readSMTPReply(reader, "250"); err != nil {
+
+			return err
+
+		}
+
+		if _, err := fmt.Fprintf(connection, "STARTTLS\r\n"); err != nil {
+
+			return err
+
+		}
+
+		_, err := readSMTPReply(reader, "220")
+
+		return err
+
+	case StartTLSIMAP:
+
+		if _, err := reader.ReadString('\n'); err != nil {
+
+			return err
+
+		}
+
+		if _, err := fmt.Fprintf(connection, "a001 STARTTLS\r\n"); err != nil {
+
+			return err
+
+		}
+
+		for {
+
+			line, err := reader.ReadString('\n')
+
+			if err != nil {
+
+				return err
+
+			}
+
+			if strings.HasPrefix(line, "a001 ") {
+
+				if !strings.HasPrefix(line, "a001 OK") {
+
+					return fmt.Errorf("STARTTLS rejected: %s", strings.TrimSpace(line))
+
+				}
+
+				return nil
+
+			}
+
+		}
+
+	case StartTLSPOP3:
+
+		if _, err := reader.ReadString('\n'); err != nil {
+
+			return err
+
+		}
+
+		if _, err := fmt.Fprintf(connection, "STLS\r\n"); err != nil {
+
+			return err
+
+		}
+
+		line, err := reader.ReadString('\n')
+
+		if err != nil {
+
+			return err
+
+		}
+
+		if !strings.HasPrefix(line, "+OK") {
+
+			return fmt.Errorf("STLS rejected: %s", strings.TrimSpace(line))
+
+		}
+
+		return nil
+
+	default:
+
+		return fmt.Errorf("unsupported STARTTLS protocol %q", protocol)
+
+	}
+
+}
+
+/*
+readSMTPReply reads a possibly multi-line SMTP reply and checks its code.
+*/
+func readSMTPReply(reader *bufio.Reader, code string) (string, error) {
+
+	for {
+
+		line, err := reader.ReadString('\n')
+
+		if err != nil {
+
+			return "", err
+
+		}
+
+		if len(line) < 4 || !strings.HasPrefix(line, code) {
+
+			return "", fmt.Errorf("unexpected SMTP reply: %s", strings.TrimSpace(line))
+
+		}
+
+		if line[3] == ' ' {
+
+			return line, nil
+
+		}
+
+	}
+
+}
+
+/*
+fetchChain connects to host:port, performs the TLS handshake (after STARTTLS when requested) and returns
+the connection state. The chain is not verified here so that invalid chains can still be reported.
+*/
+func fetchChain(host string, port int, serverName string, protocol string, timeout time.Duration) (tls.ConnectionState, error) {
+
+	dialer := &net.Dialer{Timeout: timeout}
+
+	connection, err := dialer.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+
+	if err != nil {
+
+		return tls.ConnectionState{}, err
+
+	}
+
+	defer connection.Close()
+
+	connection.SetDeadline(time.Now().Add(timeout))
+
+	if protocol != "" {
+
+		if err := startTLS(connection, protocol); err != nil {
+
+			return tls.ConnectionState{}, fmt.Errorf("STARTTLS failed: %v", err)
+
+		}
+
+	}
+
+	client := tls.Client(connection, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+
+	if err := client.Handshake(); err != nil {
+
+		return tls.ConnectionState{}, err
+
+	}
+
+	return client.ConnectionState(), nil
+
+}
+
+/*
+verifyChain validates the peer chain against the system roots for serverName and returns the validation errors.
+*/
+func verifyChain(certificates []*x509.Certificate, serverName string) []string {
+
+	intermediates := x509.NewCertPool()
+
+	for _, certificate := range certificates[1:] {
+
+		intermediates.AddCert(certificate)
+
+	}
+
+	options := x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates}
+
+	if _, err := certificates[0].Verify(options); err != nil {
+
+		return []string{err.Error()}
+
+	}
+
+	return nil
+
+}
+
+/*
+keySize returns the algorithm and size in bits of the public key of certificate.
+*/
+func keySize(certificate *x509.Certificate) (string, int) {
+
+	switch key := certificate.PublicKey.(type) {
+
+	case *rsa.PublicKey:
+
+		return "RSA", key.N.BitLen()
+
+	case *ecdsa.PublicKey:
+
+		return "ECDSA", key.Curve.Params().BitSize
+
+	case ed25519.PublicKey:
+
+		return "Ed25519", 256
+
+	default:
+
+		return certificate.PublicKeyAlgorithm.String(), 0
+
+	}
+
+}
+
+func daysUntil(moment time.Time) float64 {
+
+	return math.Floor(time.Until(moment).Hours()/24*100) / 100
+
+}
+
+/*
+check connects to a TLS service and reports the certificate of the service and the state of its chain.
+
+Parameters:
+- responseData: The request map. It should include ip and may include:
+  - port: The TCP port of the service (default 443).
+  - serverName: The name sent with SNI and validated against the certificate (default ip).
+  - starttls: smtp, imap or pop3 to upgrade a plain-text connection first.
+  - timeout: The timeout of the check in milliseconds (default 10000).
+
+Returns:
+- A JSON string with subject, issuer, SANs, days to expiry, key size, signature algorithm and chain validation
+errors in result. The request fails only when no certificate could be retrieved; expiry alerts come from the
+thresholds on CertificateDaysToExpiry.
+*/
+func check(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	host, ok := responseData["ip"].(string)
+
+	if !ok || host == "" {
+
+		errorData["missing_fields_error"] = "Missing required field: IP"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	port := DefaultPort
+
+	if value, ok := responseData["port"].(float64); ok {
+
+		port = int(value)
+
+	}
+
+	serverName, _ := responseData["serverName"].(string)
+
+	if serverName == "" {
+
+		serverName = host
+
+	}
+
+	protocol, _ := responseData["starttls"].(string)
+
+	timeout := DefaultTimeout
+
+	if value, ok := responseData["timeout"].(float64); ok {
+
+		timeout = int(value)
+
+	}
+
+	state, err := fetchChain(host, port, serverName, strings.ToLower(protocol), time.Duration(timeout)*time.Millisecond)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("TLS check of %s:%d failed: %v", host, port, err))
+
+		errorData["tls_error"] = err.Error()
+
+		errorData["error_class"] = util.ClassifyError(err)
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	if len(state.PeerCertificates) == 0 {
+
+		errorData["tls_error"] = "No certificate presented"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	leaf := state.PeerCertificates[0]
+
+	keyAlgorithm, keyBits := keySize(leaf)
+
+	sans := append([]string{}, leaf.DNSNames...)
+
+	for _, address := range leaf.IPAddresses {
+
+		sans = append(sans, address.String())
+
+	}
+
+	var chain []map[string]interface{}
+
+	minimumDays := daysUntil(leaf.NotAfter)
+
+	for _, certificate := range state.PeerCertificates {
+
+		days := daysUntil(certificate.NotAfter)
+
+		minimumDays = math.Min(minimumDays, days)
+
+		chain = append(chain, map[string]interface{}{
+
+			"subject": certificate.Subject.String(),
+
+			"issuer": certificate.Issuer.String(),
+
+			"notAfter": certificate.NotAfter.UTC().Format(time.RFC3339),
+
+			"daysToExpiry": days,
+		})
+
+	}
+
+	validationErrors := verifyChain(state.PeerCertificates, serverName)
+
+	result := map[string]interface{}{
+
+		"CertificateSubject": leaf.Subject.String(),
+
+		"CertificateIssuer": leaf.Issuer.String(),
+
+		"CertificateSerialNumber": leaf.SerialNumber.String(),
+
+		"CertificateSANs": sans,
+
+		"CertificateNotBefore": leaf.NotBefore.UTC().Format(time.RFC3339),
+
+		"CertificateNotAfter": leaf.NotAfter.UTC().Format(time.RFC3339),
+
+		"CertificateDaysToExpiry": daysUntil(leaf.NotAfter),
+
+		"CertificateChainMinDaysToExpiry": minimumDays,
+
+		"CertificateKeyAlgorithm": keyAlgorithm,
+
+		"CertificateKeySize": keyBits,
+
+		"CertificateSignatureAlgorithm": leaf.SignatureAlgorithm.String(),
+
+		"CertificateChain": chain,
+
+		"CertificateChainValid": len(validationErrors) == 0,
+
+		"TLSVersion": tls.VersionName(state.Version),
+	}
+
+	if len(validationErrors) > 0 {
+
+		result["CertificateChainErrors"] = validationErrors
+
+		errorData["chain_validation_error"] = strings.Join(validationErrors, "; ")
+
+	}
+
+	responseData["result"] = result
+
+	responseData["errors"] = errorData
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+HandleProvisioning processes a discovery or provisioning request for the certificate system type.
+
+Parameters:
+- responseData: The request map, including SystemType and ip.
+
+Returns:
+- A JSON string indicating the result of the check.
+*/
+func HandleProvisioning(responseData map[string]interface{}) string {
+
+	systemType, _ := responseData["SystemType"].(string)
+
+	if systemType != SystemTypeCertificate {
+
+		errorData, exists := responseData["errors"].(map[string]interface{})
+
+		if !exists {
+
+			errorData = make(map[string]interface{})
+
+		}
+
+		errorData["provisionError"] = "Unknown provision type"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	logInstance.LogInfo("Checking TLS certificate for IP: " + fmt.Sprint(responseData["ip"]))
+
+	return check(responseData)
+
+}
+
+func errorResponse(responseData map[string]interface{}, errorData map[string]interface{}) string {
+
+	responseData["errors"] = errorData
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"NMS/src/util"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	SystemTypePostgreSQL      = "postgresql"
+	SystemTypeMySQL           = "mysql"
+	DefaultTimeout            = 10000 // milliseconds
+	DefaultSlowQuerySeconds   = 5
+	DefaultPostgreSQLPort     = 5432
+	DefaultMySQLPort          = 3306
+	DefaultPostgreSQLDatabase = "postgres"
+)
+
+var logInstance = util.InitializeLogger()
+
+/*
+connection holds the fields of a database request.
+*/
+type connection struct {
+	host             string
+	port             int
+	username         string
+	password         string
+	database         string
+	sslMode          string
+	slowQuerySeconds float64
+}
+
+/*
+engine describes how to reach and inspect one database system.
+open returns the driver name and data source name; collect adds the health metrics to result and reports the
+queries that failed in errorData, so that one missing privilege does not hide the other metrics.
+*/
+type engine struct {
+	defaultPort int
+	open        func(connection, time.Duration) (string, string)
+	collect     func(context.Context, *sql.DB, connection, map[string]interface{}, map[string]interface{})
+}
+
+var engines = map[string]engine{
+
+	SystemTypePostgreSQL: {defaultPort: DefaultPostgreSQLPort, open: postgreSQLSource, collect: collectPostgreSQL},
+
+	SystemTypeMySQL: {defaultPort: DefaultMySQLPort, open: mySQLSource, collect: collectMySQL},
+}
+
+/*
+check connects to a database with the credentials of the request and reports its health.
+
+Parameters:
+- responseData: The request map. It should include ip, username and password, and may include:
+  - port: The port of the server (default 5432 for PostgreSQL and 3306 for MySQL).
+  - database: The database to connect to (default postgres for PostgreSQL, none for MySQL).
+  - sslMode: The PostgreSQL sslmode (default disable), or the MySQL tls setting (default false).
+  - slowQuerySeconds: The duration after which a running query counts as slow (default 5).
+  - timeout: The timeout of the check in milliseconds (default 10000).
+
+Returns:
+- A JSON string with connection count, replication lag, database sizes, slow query count and uptime in result.
+The request fails when the server cannot be reached or rejects the credentials.
+*/
+func check(responseData map[string]interface{}, target engine) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	host, _ := responseData["ip"].(string)
+
+	username, _ := responseData["username"].(string)
+
+	password, _ := responseData["password"].(string)
+
+	if host == "" || username == "" {
+
+		errorData["missing_fields_error"] = "Missing required fields: IP and username"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	details := connection{host: host, port: target.defaultPort, username: username, password: password, slowQuerySeconds: DefaultSlowQuerySeconds}
+
+	if value, ok := responseData["port"].(float64); ok {
+
+		details.port = int(value)
+
+	}
+
+	details.database, _ = responseData["database"].(string)
+
+	details.sslMode, _ = responseData["sslMode"].(string)
+
+	if value, ok := responseData["slowQuerySeconds"].(float64); ok && value > 0 {
+
+		details.slowQuerySeconds = value
+
+	}
+
+	timeout := DefaultTimeout
+
+	if value, ok := responseData["timeout"].(float64); ok {
+
+		timeout = int(value)
+
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+
+	defer cancel()
+
+	driver, source := target.open(details, time.Duration(timeout)*time.Millisecond)
+
+	db, err := sql.Open(driver, source)
+
+	if err != nil {
+
+		errorData["connection_error"] = err.Error()
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	started := time.Now()
+
+	if err := db.PingContext(ctx); err != nil {
+
+		logInstance.LogError(fmt.Errorf("connection to %s database %s:%d failed: %v", driver, host, details.port, err))
+
+		errorData["connection_error"] = err.Error()
+
+		errorData["error_class"] = util.ClassifyError(err)
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	result := map[string]interface{}{
+
+		"DatabaseConnectTimeMs": math.Round(float64(time.Since(started))/float64(time.Millisecond)*100) / 100,
+	}
+
+	target.collect(ctx, db, details, result, errorData)
+
+	responseData["result"] = result
+
+	responseData["errors"] = errorData
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+HandleProvisioning processes a discovery or provisioning request for the postgresql and mysql system types.
+
+Parameters:
+- responseData: The request map, including SystemType, ip and credentials.
+
+Returns:
+- A JSON string indicating the result of the check.
+*/
+func HandleProvisioning(responseData map[string]interface{}) string {
+
+	systemType, _ := responseData["SystemType"].(string)
+
+	target, exists := engines[systemType]
+
+	if !exists {
+
+		errorData, exists := responseData["errors"].(map[string]interface{})
+
+		if !exists {
+
+			errorData = make(map[string]interface{})
+
+		}
+
+		errorData["provisionError"] = "Unknown provision type"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	logInstance.LogInfo(fmt.Sprintf("Checking %s health for IP: %v", systemType, responseData["ip"]))
+
+	return check(responseData, target)
+
+}
+
+/*
+queryError records the failure of the query behind metric without failing the request.
+*/
+func queryError(errorData map[string]interface{}, metric string, err error) {
+
+	logInstance.LogWarning(fmt.Sprintf("query for %s failed: %v", metric, err))
+
+	errorData["query_error_"+metric] = err.Error()
+
+}
+
+func errorResponse(responseData map[string]interface{}, errorData map[string]interface{}) string {
+
+	responseData["errors"] = errorData
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
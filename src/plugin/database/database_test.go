@@ -0,0 +1,206 @@
+package database
+
+import (
+	"NMS/src/util"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestPostgreSQLSource(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		details  connection
+		timeout  time.Duration
+		host     string
+		database string
+		sslMode  string
+		seconds  string
+	}{
+		{"defaults", connection{host: "10.0.0.5", port: 5432, username: "nms", password: "secret"}, 10 * time.Second, "10.0.0.5:5432", "postgres", "disable", "10"},
+		{"database and sslmode", connection{host: "10.0.0.5", port: 6432, username: "nms", password: "secret", database: "inventory", sslMode: "verify-full"}, 3 * time.Second, "10.0.0.5:6432", "inventory", "verify-full", "3"},
+		{"IPv6 and short timeout", connection{host: "2001:db8::5", port: 5432, username: "nms", password: "secret"}, 200 * time.Millisecond, "[2001:db8::5]:5432", "postgres", "disable", "1"},
+		{"special characters", connection{host: "db.example.com", port: 5432, username: "nms@corp", password: "p@ss:w/rd?#% "}, 10 * time.Second, "db.example.com:5432", "postgres", "disable", "10"},
+	}
+
+	for _, test := range tests {
+
+		driver, source := postgreSQLSource(test.details, test.timeout)
+
+		if driver != "postgres" {
+
+			t.Errorf("%s: driver %q, want postgres", test.name, driver)
+
+		}
+
+		if _, err := pq.ParseURL(source); err != nil {
+
+			t.Errorf("%s: lib/pq rejects %q: %v", test.name, source, err)
+
+			continue
+
+		}
+
+		parsed, err := url.Parse(source)
+
+		if err != nil {
+
+			t.Errorf("%s: invalid URL %q: %v", test.name, source, err)
+
+			continue
+
+		}
+
+		password, _ := parsed.User.Password()
+
+		if parsed.User.Username() != test.details.username || password != test.details.password {
+
+			t.Errorf("%s: credentials %q/%q, want %q/%q", test.name, parsed.User.Username(), password, test.details.username, test.details.password)
+
+		}
+
+		query := parsed.Query()
+
+		if parsed.Host != test.host || parsed.Path != "/"+test.database || query.Get("sslmode") != test.sslMode || query.Get("connect_timeout") != test.seconds {
+
+			t.Errorf("%s: got host %q, path %q, sslmode %q, connect_timeout %q", test.name, parsed.Host, parsed.Path, query.Get("sslmode"), query.Get("connect_timeout"))
+
+		}
+
+	}
+
+}
+
+func TestMySQLSource(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		details connection
+		addr    string
+	}{
+		{"defaults", connection{host: "10.0.0.6", port: 3306, username: "nms", password: "secret"}, "10.0.0.6:3306"},
+		{"database and tls", connection{host: "10.0.0.6", port: 3307, username: "nms", password: "secret", database: "inventory", sslMode: "skip-verify"}, "10.0.0.6:3307"},
+		{"IPv6", connection{host: "2001:db8::6", port: 3306, username: "nms", password: "secret"}, "[2001:db8::6]:3306"},
+		{"special characters", connection{host: "db.example.com", port: 3306, username: "nms", password: "p@ss:w/rd?#%()"}, "db.example.com:3306"},
+	}
+
+	for _, test := range tests {
+
+		driver, source := mySQLSource(test.details, 5*time.Second)
+
+		if driver != "mysql" {
+
+			t.Errorf("%s: driver %q, want mysql", test.name, driver)
+
+		}
+
+		config, err := mysql.ParseDSN(source)
+
+		if err != nil {
+
+			t.Errorf("%s: go-sql-driver rejects %q: %v", test.name, source, err)
+
+			continue
+
+		}
+
+		if config.User != test.details.username || config.Passwd != test.details.password {
+
+			t.Errorf("%s: credentials %q/%q, want %q/%q", test.name, config.User, config.Passwd, test.details.username, test.details.password)
+
+		}
+
+		if config.Net != "tcp" || config.Addr != test.addr || config.DBName != test.details.database || config.TLSConfig != test.details.sslMode {
+
+			t.Errorf("%s: got net %q, addr %q, database %q, tls %q", test.name, config.Net, config.Addr, config.DBName, config.TLSConfig)
+
+		}
+
+		if config.Timeout != 5*time.Second || config.ReadTimeout != 5*time.Second {
+
+			t.Errorf("%s: timeouts %v/%v, want 5s", test.name, config.Timeout, config.ReadTimeout)
+
+		}
+
+	}
+
+}
+
+func TestDriverErrorClasses(t *testing.T) {
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}
+
+	tests := []struct {
+		name  string
+		err   error
+		class string
+	}{
+		{"postgresql wrong password", &pq.Error{Code: "28P01", Message: `password authentication failed for user "nms"`}, util.ErrorClassAuth},
+		{"mysql access denied", &mysql.MySQLError{Number: 1045, Message: "Access denied for user 'nms'@'10.0.0.1' (using password: YES)"}, util.ErrorClassAuth},
+		{"connection refused", refused, util.ErrorClassConnectionRefused},
+		{"wrapped connection refused", fmt.Errorf("dial failed: %w", refused), util.ErrorClassConnectionRefused},
+		{"connect timeout", context.DeadlineExceeded, util.ErrorClassTimeout},
+		{"postgresql unknown database", &pq.Error{Code: "3D000", Message: `database "missing" does not exist`}, util.ErrorClassOther},
+	}
+
+	for _, test := range tests {
+
+		if class := util.ClassifyError(test.err); class != test.class {
+
+			t.Errorf("%s: class %q, want %q", test.name, class, test.class)
+
+		}
+
+	}
+
+}
+
+func TestCheckClassifiesUnreachableServer(t *testing.T) {
+
+	// a port that was just released refuses connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+
+		t.Fatal(err)
+
+	}
+
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	listener.Close()
+
+	for systemType, target := range engines {
+
+		request := map[string]interface{}{"ip": "127.0.0.1", "port": float64(port), "username": "nms", "password": "secret", "timeout": 2000.0}
+
+		var response struct {
+			Status string                 `json:"status"`
+			Errors map[string]interface{} `json:"errors"`
+		}
+
+		if err := json.Unmarshal([]byte(check(request, target)), &response); err != nil {
+
+			t.Fatalf("%s: invalid response: %v", systemType, err)
+
+		}
+
+		if response.Status != "fail" || response.Errors["error_class"] != util.ErrorClassConnectionRefused {
+
+			t.Errorf("%s: status %q, errors %v, want fail with error_class %s", systemType, response.Status, response.Errors, util.ErrorClassConnectionRefused)
+
+		}
+
+	}
+
+}
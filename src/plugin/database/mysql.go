@@ -0,0 +1,260 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+mySQLStatusVariables maps the global status variables reported by a MySQL check to their result metric.
+Slow_queries is the cumulative count of queries that exceeded long_query_time since the server started.
+*/
+var mySQLStatusVariables = map[string]string{
+	"Threads_connected": "MySQLConnections",
+	"Threads_running":   "MySQLThreadsRunning",
+	"Uptime":            "MySQLUptimeSeconds",
+	"Slow_queries":      "MySQLSlowQueriesTotal",
+}
+
+/*
+mySQLSource returns the go-sql-driver data source name of a connection.
+*/
+func mySQLSource(details connection, timeout time.Duration) (string, string) {
+
+	config := mysql.NewConfig()
+
+	config.User = details.username
+
+	config.Passwd = details.password
+
+	config.Net = "tcp"
+
+	config.Addr = net.JoinHostPort(details.host, strconv.Itoa(details.port))
+
+	config.DBName = details.database
+
+	config.Timeout = timeout
+
+	config.ReadTimeout = timeout
+
+	config.TLSConfig = details.sslMode
+
+	return "mysql", config.FormatDSN()
+
+}
+
+/*
+collectMySQL reports the health of a MySQL server:
+- MySQLConnections, MySQLThreadsRunning and MySQLMaxConnections: The connected and running threads and the limit.
+- MySQLUptimeSeconds: The time since the server started.
+- MySQLReplicationRole, MySQLReplicationRunning and MySQLReplicationLagSeconds: The state of the replica threads
+and Seconds_Behind_Source of a replica.
+- MySQLDatabaseSizes: The data and index size in bytes of every schema.
+- MySQLSlowQueries and MySQLSlowQueriesTotal: The queries running for longer than slowQuerySeconds, and the
+Slow_queries counter of the server.
+*/
+func collectMySQL(ctx context.Context, db *sql.DB, details connection, result map[string]interface{}, errorData map[string]interface{}) {
+
+	var version string
+
+	var maxConnections int64
+
+	if err := db.QueryRowContext(ctx, "SELECT VERSION(), @@max_connections").Scan(&version, &maxConnections); err != nil {
+
+		queryError(errorData, "MySQLVersion", err)
+
+	} else {
+
+		result["MySQLVersion"] = version
+
+		result["MySQLMaxConnections"] = maxConnections
+
+	}
+
+	rows, err := db.QueryContext(ctx, "SHOW GLOBAL STATUS WHERE Variable_name IN ('Threads_connected', 'Threads_running', 'Uptime', 'Slow_queries')")
+
+	if err != nil {
+
+		queryError(errorData, "MySQLConnections", err)
+
+	} else {
+
+		for rows.Next() {
+
+			var name, value string
+
+			if err := rows.Scan(&name, &value); err != nil {
+
+				queryError(errorData, "MySQLConnections", err)
+
+				break
+
+			}
+
+			if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+
+				result[mySQLStatusVariables[name]] = number
+
+			}
+
+		}
+
+		rows.Close()
+
+	}
+
+	collectMySQLReplication(ctx, db, result, errorData)
+
+	rows, err = db.QueryContext(ctx, "SELECT table_schema, CAST(COALESCE(SUM(data_length + index_length), 0) AS UNSIGNED) FROM information_schema.tables GROUP BY table_schema")
+
+	if err != nil {
+
+		queryError(errorData, "MySQLDatabaseSizes", err)
+
+	} else {
+
+		sizes := make(map[string]int64)
+
+		for rows.Next() {
+
+			var name string
+
+			var size int64
+
+			if err := rows.Scan(&name, &size); err != nil {
+
+				queryError(errorData, "MySQLDatabaseSizes", err)
+
+				break
+
+			}
+
+			sizes[name] = size
+
+		}
+
+		rows.Close()
+
+		result["MySQLDatabaseSizes"] = sizes
+
+	}
+
+	var slowQueries int64
+
+	query := "SELECT COUNT(*) FROM information_schema.processlist WHERE command = 'Query' AND time >= ?"
+
+	if err := db.QueryRowContext(ctx, query, details.slowQuerySeconds).Scan(&slowQueries); err != nil {
+
+		queryError(errorData, "MySQLSlowQueries", err)
+
+	} else {
+
+		result["MySQLSlowQueries"] = slowQueries
+
+	}
+
+}
+
+/*
+collectMySQLReplication reads the replica status of the server. SHOW REPLICA STATUS replaced SHOW SLAVE STATUS in
+MySQL 8.0.22, so the older statement and column names are used when the newer one is rejected.
+A server without replica status is reported as a primary.
+*/
+func collectMySQLReplication(ctx context.Context, db *sql.DB, result map[string]interface{}, errorData map[string]interface{}) {
+
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+
+	if err != nil {
+
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+
+	}
+
+	if err != nil {
+
+		queryError(errorData, "MySQLReplicationRole", err)
+
+		return
+
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+
+		queryError(errorData, "MySQLReplicationRole", err)
+
+		return
+
+	}
+
+	if !rows.Next() {
+
+		result["MySQLReplicationRole"] = "primary"
+
+		return
+
+	}
+
+	values := make([]sql.NullString, len(columns))
+
+	pointers := make([]interface{}, len(columns))
+
+	for i := range values {
+
+		pointers[i] = &values[i]
+
+	}
+
+	if err := rows.Scan(pointers...); err != nil {
+
+		queryError(errorData, "MySQLReplicationRole", err)
+
+		return
+
+	}
+
+	status := make(map[string]sql.NullString, len(columns))
+
+	for i, column := range columns {
+
+		status[column] = values[i]
+
+	}
+
+	field := func(current string, legacy string) sql.NullString {
+
+		if value, ok := status[current]; ok {
+
+			return value
+
+		}
+
+		return status[legacy]
+
+	}
+
+	result["MySQLReplicationRole"] = "replica"
+
+	result["MySQLReplicationRunning"] = field("Replica_IO_Running", "Slave_IO_Running").String == "Yes" &&
+		field("Replica_SQL_Running", "Slave_SQL_Running").String == "Yes"
+
+	// the lag is NULL while the replication threads are stopped
+	if lag := field("Seconds_Behind_Source", "Seconds_Behind_Master"); lag.Valid {
+
+		if seconds, err := strconv.ParseFloat(lag.String, 64); err == nil {
+
+			result["MySQLReplicationLagSeconds"] = seconds
+
+		}
+
+	}
+
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+/*
+postgreSQLSource returns the lib/pq data source name of a connection.
+*/
+func postgreSQLSource(details connection, timeout time.Duration) (string, string) {
+
+	database := details.database
+
+	if database == "" {
+
+		database = DefaultPostgreSQLDatabase
+
+	}
+
+	sslMode := details.sslMode
+
+	if sslMode == "" {
+
+		sslMode = "disable"
+
+	}
+
+	seconds := int(timeout / time.Second)
+
+	if seconds < 1 {
+
+		seconds = 1
+
+	}
+
+	source := url.URL{
+
+		Scheme: "postgres",
+
+		User: url.UserPassword(details.username, details.password),
+
+		Host: net.JoinHostPort(details.host, strconv.Itoa(details.port)),
+
+		Path: "/" + database,
+
+		RawQuery: url.Values{"sslmode": {sslMode}, "connect_timeout": {strconv.Itoa(seconds)}}.Encode(),
+	}
+
+	return "postgres", source.String()
+
+}
+
+/*
+collectPostgreSQL reports the health of a PostgreSQL server:
+- PostgreSQLConnections and PostgreSQLMaxConnections: The backends of pg_stat_activity and the configured limit.
+- PostgreSQLUptimeSeconds: The time since the postmaster started.
+- PostgreSQLReplicationRole and PostgreSQLReplicationLagSeconds: The replay lag of a standby, or the largest
+replay lag of the standbys of a primary.
+- PostgreSQLDatabaseSizes: The size in bytes of every database that accepts connections.
+- PostgreSQLSlowQueries: The queries that have been running for longer than slowQuerySeconds.
+*/
+func collectPostgreSQL(ctx context.Context, db *sql.DB, details connection, result map[string]interface{}, errorData map[string]interface{}) {
+
+	var version string
+
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err != nil {
+
+		queryError(errorData, "PostgreSQLVersion", err)
+
+	} else {
+
+		result["PostgreSQLVersion"] = version
+
+	}
+
+	var connections, maxConnections int64
+
+	if err := db.QueryRowContext(ctx, "SELECT count(*), current_setting('max_connections')::int FROM pg_stat_activity").Scan(&connections, &maxConnections); err != nil {
+
+		queryError(errorData, "PostgreSQLConnections", err)
+
+	} else {
+
+		result["PostgreSQLConnections"] = connections
+
+		result["PostgreSQLMaxConnections"] = maxConnections
+
+	}
+
+	var uptime float64
+
+	if err := db.QueryRowContext(ctx, "SELECT EXTRACT(EPOCH FROM now() - pg_postmaster_start_time())::float8").Scan(&uptime); err != nil {
+
+		queryError(errorData, "PostgreSQLUptimeSeconds", err)
+
+	} else {
+
+		result["PostgreSQLUptimeSeconds"] = int64(uptime)
+
+	}
+
+	var standby bool
+
+	if err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&standby); err != nil {
+
+		queryError(errorData, "PostgreSQLReplicationRole", err)
+
+	} else if standby {
+
+		result["PostgreSQLReplicationRole"] = "standby"
+
+		// without replayed transactions there is no lag to measure
+		var lag sql.NullFloat64
+
+		if err := db.QueryRowContext(ctx, "SELECT EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())::float8").Scan(&lag); err != nil {
+
+			queryError(errorData, "PostgreSQLReplicationLagSeconds", err)
+
+		} else if lag.Valid {
+
+			result["PostgreSQLReplicationLagSeconds"] = lag.Float64
+
+		}
+
+	} else {
+
+		result["PostgreSQLReplicationRole"] = "primary"
+
+		var replicas int64
+
+		var lag sql.NullFloat64
+
+		if err := db.QueryRowContext(ctx, "SELECT count(*), max(EXTRACT(EPOCH FROM replay_lag))::float8 FROM pg_stat_replication").Scan(&replicas, &lag); err != nil {
+
+			queryError(errorData, "PostgreSQLReplicationLagSeconds", err)
+
+		} else {
+
+			result["PostgreSQLReplicas"] = replicas
+
+			if lag.Valid {
+
+				result["PostgreSQLReplicationLagSeconds"] = lag.Float64
+
+			}
+
+		}
+
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT datname, pg_database_size(datname) FROM pg_database WHERE datallowconn")
+
+	if err != nil {
+
+		queryError(errorData, "PostgreSQLDatabaseSizes", err)
+
+	} else {
+
+		sizes := make(map[string]int64)
+
+		for rows.Next() {
+
+			var name string
+
+			var size int64
+
+			if err := rows.Scan(&name, &size); err != nil {
+
+				queryError(errorData, "PostgreSQLDatabaseSizes", err)
+
+				break
+
+			}
+
+			sizes[name] = size
+
+		}
+
+		rows.Close()
+
+		result["PostgreSQLDatabaseSizes"] = sizes
+
+	}
+
+	var slowQueries int64
+
+	query := "SELECT count(*) FROM pg_stat_activity WHERE state = 'active' AND now() - query_start > make_interval(secs => $1)"
+
+	if err := db.QueryRowContext(ctx, query, details.slowQuerySeconds).Scan(&slowQueries); err != nil {
+
+		queryError(errorData, "PostgreSQLSlowQueries", err)
+
+	} else {
+
+		result["PostgreSQLSlowQueries"] = slowQueries
+
+	}
+
+}
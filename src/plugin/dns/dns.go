@@ -0,0 +1,634 @@
+package dns
+
+import (
+	"NMS/src/util"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	SystemTypeDNS  = "dns"
+	DefaultPort    = 53
+	DefaultTimeout = 5000 // milliseconds
+	RecordA        = "A"
+	RecordAAAA     = "AAAA"
+	RecordCNAME    = "CNAME"
+	RecordMX       = "MX"
+	RecordTXT      = "TXT"
+	maxMessageSize = 65535
+)
+
+var (
+	logInstance = util.InitializeLogger()
+
+	recordTypes = map[string]dnsmessage.Type{
+		RecordA:     dnsmessage.TypeA,
+		RecordAAAA:  dnsmessage.TypeAAAA,
+		RecordCNAME: dnsmessage.TypeCNAME,
+		RecordMX:    dnsmessage.TypeMX,
+		RecordTXT:   dnsmessage.TypeTXT,
+	}
+)
+
+/*
+client sends queries to one DNS server. Unlike the system resolver, it never reads /etc/hosts, appends search
+domains or follows the answers of another server, so the check measures the configured server only.
+*/
+type client struct {
+	server   string
+	protocol string
+}
+
+/*
+query sends a recursive query for the records of recordType of name and returns the answers of that type.
+A UDP answer that is truncated is queried again over TCP, unless the protocol is set to udp.
+
+Returns:
+- The answers.
+- A *net.DNSError that IsNotFound if the name does not exist or has no records of recordType.
+- A *net.DNSError that IsTimeout if the server did not answer in time, or another error if the query failed.
+*/
+func (c client) query(ctx context.Context, name string, recordType dnsmessage.Type) ([]dnsmessage.Resource, error) {
+
+	if !strings.HasSuffix(name, ".") {
+
+		name += "."
+
+	}
+
+	questionName, err := dnsmessage.NewName(name)
+
+	if err != nil {
+
+		return nil, fmt.Errorf("invalid name %q: %v", name, err)
+
+	}
+
+	request := dnsmessage.Message{
+
+		Header: dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+
+		Questions: []dnsmessage.Question{{Name: questionName, Type: recordType, Class: dnsmessage.ClassINET}},
+	}
+
+	packed, err := request.Pack()
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	network := c.protocol
+
+	if network == "" {
+
+		network = "udp"
+
+	}
+
+	response, err := c.exchange(ctx, network, packed, request.ID)
+
+	if err == nil && response.Truncated && c.protocol == "" {
+
+		response, err = c.exchange(ctx, "tcp", packed, request.ID)
+
+	}
+
+	if err != nil {
+
+		var netError net.Error
+
+		if errors.As(err, &netError) && netError.Timeout() {
+
+			return nil, &net.DNSError{Err: "i/o timeout", Name: name, Server: c.server, IsTimeout: true}
+
+		}
+
+		return nil, err
+
+	}
+
+	switch response.RCode {
+
+	case dnsmessage.RCodeSuccess:
+
+	case dnsmessage.RCodeNameError:
+
+		return nil, &net.DNSError{Err: "no such host", Name: name, Server: c.server, IsNotFound: true}
+
+	default:
+
+		return nil, &net.DNSError{Err: "server answered " + response.RCode.String(), Name: name, Server: c.server,
+			IsTemporary: response.RCode == dnsmessage.RCodeServerFailure}
+
+	}
+
+	var answers []dnsmessage.Resource
+
+	for _, answer := range response.Answers {
+
+		if answer.Header.Type == recordType {
+
+			answers = append(answers, answer)
+
+		}
+
+	}
+
+	if len(answers) == 0 {
+
+		return nil, &net.DNSError{Err: "no " + strings.TrimPrefix(recordType.String(), "Type") + " records", Name: name, Server: c.server, IsNotFound: true}
+
+	}
+
+	return answers, nil
+
+}
+
+/*
+exchange sends a packed query over network and reads the response with the same ID. Over UDP, datagrams with
+another ID, such as late answers to an earlier query, are skipped; over TCP, messages carry a 2-byte length prefix.
+*/
+func (c client) exchange(ctx context.Context, network string, packed []byte, id uint16) (*dnsmessage.Message, error) {
+
+	var dialer net.Dialer
+
+	connection, err := dialer.DialContext(ctx, network, c.server)
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	defer connection.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+
+		connection.SetDeadline(deadline)
+
+	}
+
+	buffer := make([]byte, maxMessageSize)
+
+	if network == "tcp" {
+
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(packed)))
+
+		if _, err := connection.Write(append(framed, packed...)); err != nil {
+
+			return nil, err
+
+		}
+
+		if _, err := io.ReadFull(connection, buffer[:2]); err != nil {
+
+			return nil, err
+
+		}
+
+		length := binary.BigEndian.Uint16(buffer[:2])
+
+		if _, err := io.ReadFull(connection, buffer[:length]); err != nil {
+
+			return nil, err
+
+		}
+
+		response := &dnsmessage.Message{}
+
+		if err := response.Unpack(buffer[:length]); err != nil {
+
+			return nil, fmt.Errorf("invalid response from %s: %v", c.server, err)
+
+		}
+
+		if response.ID != id || !response.Response {
+
+			return nil, fmt.Errorf("response from %s does not match the query", c.server)
+
+		}
+
+		return response, nil
+
+	}
+
+	if _, err := connection.Write(packed); err != nil {
+
+		return nil, err
+
+	}
+
+	for {
+
+		n, err := connection.Read(buffer)
+
+		if err != nil {
+
+			return nil, err
+
+		}
+
+		response := &dnsmessage.Message{}
+
+		if err := response.Unpack(buffer[:n]); err != nil || response.ID != id || !response.Response {
+
+			continue
+
+		}
+
+		return response, nil
+
+	}
+
+}
+
+/*
+lookup queries the records of recordType for name.
+
+Returns:
+- The answers as reported in the result, and the normalized values they are compared with.
+- The lookup error, if any.
+*/
+func lookup(ctx context.Context, resolver client, name string, recordType string) (interface{}, []string, error) {
+
+	queryType, supported := recordTypes[recordType]
+
+	if !supported {
+
+		return nil, nil, fmt.Errorf("unsupported record type %q", recordType)
+
+	}
+
+	records, err := resolver.query(ctx, name, queryType)
+
+	switch recordType {
+
+	case RecordA, RecordAAAA:
+
+		values := make([]string, 0, len(records))
+
+		for _, record := range records {
+
+			switch body := record.Body.(type) {
+
+			case *dnsmessage.AResource:
+
+				values = append(values, net.IP(body.A[:]).String())
+
+			case *dnsmessage.AAAAResource:
+
+				values = append(values, net.IP(body.AAAA[:]).String())
+
+			}
+
+		}
+
+		return values, values, err
+
+	case RecordCNAME:
+
+		if err != nil {
+
+			return nil, nil, err
+
+		}
+
+		target := records[0].Body.(*dnsmessage.CNAMEResource).CNAME.String()
+
+		return target, []string{normalize(target)}, nil
+
+	case RecordMX:
+
+		answers := make([]map[string]interface{}, 0, len(records))
+
+		values := make([]string, 0, len(records))
+
+		for _, record := range records {
+
+			body := record.Body.(*dnsmessage.MXResource)
+
+			answers = append(answers, map[string]interface{}{"host": body.MX.String(), "preference": body.Pref})
+
+			values = append(values, normalize(body.MX.String()))
+
+		}
+
+		return answers, values, err
+
+	default:
+
+		values := make([]string, 0, len(records))
+
+		for _, record := range records {
+
+			// the strings of a TXT record are one value split in chunks of up to 255 bytes
+			values = append(values, strings.Join(record.Body.(*dnsmessage.TXTResource).TXT, ""))
+
+		}
+
+		return values, values, err
+
+	}
+
+}
+
+/*
+unexpectedAnswers returns the answers that are not among the expected values. Comparison ignores case and
+trailing dots; an empty answer set is reported as unexpected when values were expected.
+*/
+func unexpectedAnswers(answers []string, expected []interface{}) []string {
+
+	allowed := make(map[string]bool, len(expected))
+
+	for _, value := range expected {
+
+		allowed[normalize(fmt.Sprint(value))] = true
+
+	}
+
+	var unexpected []string
+
+	for _, answer := range answers {
+
+		if !allowed[normalize(answer)] {
+
+			unexpected = append(unexpected, answer)
+
+		}
+
+	}
+
+	if len(answers) == 0 && len(allowed) > 0 {
+
+		unexpected = append(unexpected, "<no answer>")
+
+	}
+
+	sort.Strings(unexpected)
+
+	return unexpected
+
+}
+
+/*
+classify returns the error class of a failed query. A server failure (SERVFAIL) is a server error; the other
+failures are classified like those of the other plugins.
+*/
+func classify(err error) string {
+
+	var dnsError *net.DNSError
+
+	if errors.As(err, &dnsError) && dnsError.IsTemporary && !dnsError.IsTimeout {
+
+		return util.ErrorClassServerError
+
+	}
+
+	return util.ClassifyError(err)
+
+}
+
+func normalize(value string) string {
+
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), ".")
+
+}
+
+/*
+check queries the resolver of a dns request for a name and compares the answers with the expected values.
+
+Parameters:
+- responseData: The request map. It should include ip (the resolver) and name, and may include:
+  - port: The port of the resolver (default 53).
+  - protocol: udp or tcp (default udp, with the usual fallback to tcp for truncated answers).
+  - recordTypes: The record types to query among A, AAAA, CNAME, MX and TXT (default A).
+  - expected: The accepted answers by record type, e.g. {"A": ["192.0.2.10"]}.
+  - timeout: The timeout of every query in milliseconds (default 5000).
+
+Returns:
+- A JSON string with the answers and resolution time of every record type in result. The request fails when
+a query fails or returns an answer that is not expected.
+*/
+func check(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	server, _ := responseData["ip"].(string)
+
+	name, _ := responseData["name"].(string)
+
+	if server == "" || name == "" {
+
+		errorData["missing_fields_error"] = "Missing required fields: IP and name"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	port := DefaultPort
+
+	if value, ok := responseData["port"].(float64); ok {
+
+		port = int(value)
+
+	}
+
+	protocol, _ := responseData["protocol"].(string)
+
+	if protocol != "" && protocol != "udp" && protocol != "tcp" {
+
+		errorData["invalid_field_error"] = "protocol must be udp or tcp"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	recordTypes := []string{RecordA}
+
+	if values, ok := responseData["recordTypes"].([]interface{}); ok && len(values) > 0 {
+
+		recordTypes = recordTypes[:0]
+
+		for _, value := range values {
+
+			recordTypes = append(recordTypes, strings.ToUpper(fmt.Sprint(value)))
+
+		}
+
+	}
+
+	expected, _ := responseData["expected"].(map[string]interface{})
+
+	timeout := DefaultTimeout
+
+	if value, ok := responseData["timeout"].(float64); ok {
+
+		timeout = int(value)
+
+	}
+
+	resolver := client{server: net.JoinHostPort(server, strconv.Itoa(port)), protocol: protocol}
+
+	result := make(map[string]interface{})
+
+	healthy := true
+
+	total := time.Duration(0)
+
+	for _, recordType := range recordTypes {
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+
+		started := time.Now()
+
+		answers, values, err := lookup(ctx, resolver, name, recordType)
+
+		elapsed := time.Since(started)
+
+		cancel()
+
+		total += elapsed
+
+		result["DNS"+recordType+"TimeMs"] = milliseconds(elapsed)
+
+		if err != nil {
+
+			var dnsError *net.DNSError
+
+			// a name without records of this type is an answer, not an outage, unless records were expected
+			if !(errors.As(err, &dnsError) && dnsError.IsNotFound) || expected[recordType] != nil {
+
+				healthy = false
+
+				errorData["lookup_error_"+recordType] = err.Error()
+
+				// the first failure to reach the server decides the class, as it is what retries and the breaker act on
+				if current, _ := errorData["error_class"].(string); !util.IsTransportError(current) {
+
+					errorData["error_class"] = classify(err)
+
+				}
+
+			}
+
+			logInstance.LogInfo(fmt.Sprintf("DNS %s lookup of %s via %s failed: %v", recordType, name, server, err))
+
+		}
+
+		if answers != nil {
+
+			result["DNS"+recordType+"Records"] = answers
+
+		}
+
+		if accepted, ok := expected[recordType].([]interface{}); ok {
+
+			unexpected := unexpectedAnswers(values, accepted)
+
+			result["DNS"+recordType+"Match"] = len(unexpected) == 0
+
+			if len(unexpected) > 0 && err == nil {
+
+				healthy = false
+
+				errorData["unexpected_answer_"+recordType] = fmt.Sprintf("Unexpected answers: %s", strings.Join(unexpected, ", "))
+
+			}
+
+		}
+
+	}
+
+	result["DNSResolutionTimeMs"] = milliseconds(total)
+
+	responseData["result"] = result
+
+	if !healthy {
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	responseData["errors"] = errorData
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+HandleProvisioning processes a discovery or provisioning request for the dns system type.
+
+Parameters:
+- responseData: The request map, including SystemType, ip and name.
+
+Returns:
+- A JSON string indicating the result of the queries.
+*/
+func HandleProvisioning(responseData map[string]interface{}) string {
+
+	systemType, _ := responseData["SystemType"].(string)
+
+	if systemType != SystemTypeDNS {
+
+		errorData, exists := responseData["errors"].(map[string]interface{})
+
+		if !exists {
+
+			errorData = make(map[string]interface{})
+
+		}
+
+		errorData["provisionError"] = "Unknown provision type"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	logInstance.LogInfo(fmt.Sprintf("Running DNS checks of %v via resolver %v", responseData["name"], responseData["ip"]))
+
+	return check(responseData)
+
+}
+
+func milliseconds(duration time.Duration) float64 {
+
+	return math.Round(float64(duration)/float64(time.Millisecond)*100) / 100
+
+}
+
+func errorResponse(responseData map[string]interface{}, errorData map[string]interface{}) string {
+
+	responseData["errors"] = errorData
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
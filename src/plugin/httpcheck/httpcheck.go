@@ -0,0 +1,478 @@
+package httpcheck
+
+import (
+	"NMS/src/util"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	SystemTypeHTTP      = "http"
+	DefaultTimeout      = 10000 // milliseconds
+	DefaultMaxRedirects = 10
+	maxBodyBytes        = 1 << 20
+)
+
+var logInstance = util.InitializeLogger()
+
+/*
+timings records the phases of the last request of a check, as reported by httptrace.
+*/
+type timings struct {
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	requestStart, firstByte   time.Time
+}
+
+func (t *timings) trace() *httptrace.ClientTrace {
+
+	return &httptrace.ClientTrace{
+
+		GetConn: func(string) { *t = timings{requestStart: time.Now()} },
+
+		DNSStart: func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+
+		DNSDone: func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+
+		ConnectStart: func(string, string) { t.connectStart = time.Now() },
+
+		ConnectDone: func(string, string, error) { t.connectDone = time.Now() },
+
+		TLSHandshakeStart: func() { t.tlsStart = time.Now() },
+
+		TLSHandshakeDone: func(tls.ConnectionState, error) { t.tlsDone = time.Now() },
+
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+
+}
+
+func (t *timings) toResult(result map[string]interface{}) {
+
+	phases := map[string][2]time.Time{
+
+		"HTTPDNSTimeMs": {t.dnsStart, t.dnsDone},
+
+		"HTTPConnectTimeMs": {t.connectStart, t.connectDone},
+
+		"HTTPTLSTimeMs": {t.tlsStart, t.tlsDone},
+
+		"HTTPTTFBMs": {t.requestStart, t.firstByte},
+	}
+
+	for metric, phase := range phases {
+
+		if phase[0].IsZero() || phase[1].IsZero() {
+
+			result[metric] = 0.0
+
+			continue
+
+		}
+
+		result[metric] = milliseconds(phase[1].Sub(phase[0]))
+
+	}
+
+}
+
+/*
+check requests a URL and reports its status code, response time broken into DNS/connect/TLS/TTFB,
+body match, TLS certificate expiry and redirects.
+
+Parameters:
+- responseData: The request map. It should include url and may include:
+  - method: The HTTP method (default GET).
+  - headers: Additional request headers.
+  - timeout: The timeout of the whole check in milliseconds (default 10000).
+  - expectedStatus: The accepted status codes (default any status below 400).
+  - keyword: A string the body must contain.
+  - bodyRegex: A regular expression the body must match.
+  - followRedirects: false to report redirects instead of following them.
+  - maxRedirects: The maximum number of redirects to follow (default 10).
+  - insecureSkipVerify: true to accept invalid certificates.
+
+Returns:
+- A JSON string with the measurements in result. The request fails when the URL cannot be fetched,
+the status code is not accepted or the body does not match.
+*/
+func check(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	rawURL, _ := responseData["url"].(string)
+
+	target, err := url.Parse(rawURL)
+
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+
+		errorData["missing_fields_error"] = "Missing or invalid url: an http or https URL is required"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	var bodyPattern *regexp.Regexp
+
+	if expression, ok := responseData["bodyRegex"].(string); ok && expression != "" {
+
+		bodyPattern, err = regexp.Compile(expression)
+
+		if err != nil {
+
+			errorData["invalid_field_error"] = fmt.Sprintf("invalid bodyRegex: %v", err)
+
+			return errorResponse(responseData, errorData)
+
+		}
+
+	}
+
+	method, _ := responseData["method"].(string)
+
+	if method == "" {
+
+		method = http.MethodGet
+
+	}
+
+	timeout := time.Duration(intField(responseData, "timeout", DefaultTimeout)) * time.Millisecond
+
+	maxRedirects := intField(responseData, "maxRedirects", DefaultMaxRedirects)
+
+	followRedirects, ok := responseData["followRedirects"].(bool)
+
+	if !ok {
+
+		followRedirects = true
+
+	}
+
+	insecure, _ := responseData["insecureSkipVerify"].(bool)
+
+	var redirects []string
+
+	client := &http.Client{
+
+		Transport: &http.Transport{
+
+			Proxy: http.ProxyFromEnvironment,
+
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+
+			DisableKeepAlives: true,
+		},
+
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+
+			if !followRedirects {
+
+				return http.ErrUseLastResponse
+
+			}
+
+			if len(via) > maxRedirects {
+
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+
+			}
+
+			redirects = append(redirects, request.URL.String())
+
+			return nil
+
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	defer cancel()
+
+	trace := &timings{}
+
+	request, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace.trace()), method, target.String(), nil)
+
+	if err != nil {
+
+		errorData["request_error"] = err.Error()
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	if headers, ok := responseData["headers"].(map[string]interface{}); ok {
+
+		for name, value := range headers {
+
+			request.Header.Set(name, fmt.Sprint(value))
+
+		}
+
+	}
+
+	started := time.Now()
+
+	response, err := client.Do(request)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("HTTP check of %s failed: %v", target.Redacted(), err))
+
+		errorData["request_error"] = err.Error()
+
+		errorData["error_class"] = util.ClassifyError(err)
+
+		if errors.Is(err, context.DeadlineExceeded) {
+
+			errorData["timeout_error"] = fmt.Sprintf("No response within %v", timeout)
+
+		}
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxBodyBytes))
+
+	elapsed := time.Since(started)
+
+	if err != nil {
+
+		errorData["body_error"] = err.Error()
+
+	}
+
+	result := map[string]interface{}{
+
+		"HTTPStatusCode": response.StatusCode,
+
+		"HTTPResponseTimeMs": milliseconds(elapsed),
+
+		"HTTPContentLength": len(body),
+
+		"HTTPRedirects": len(redirects),
+
+		"HTTPFinalURL": response.Request.URL.String(),
+	}
+
+	trace.toResult(result)
+
+	if len(redirects) > 0 {
+
+		result["HTTPRedirectChain"] = redirects
+
+	}
+
+	if location := response.Header.Get("Location"); location != "" && !followRedirects {
+
+		result["HTTPRedirectLocation"] = location
+
+	}
+
+	if response.TLS != nil && len(response.TLS.PeerCertificates) > 0 {
+
+		certificate := response.TLS.PeerCertificates[0]
+
+		result["HTTPCertificateExpiryDays"] = math.Floor(time.Until(certificate.NotAfter).Hours()/24*100) / 100
+
+		result["HTTPCertificateSubject"] = certificate.Subject.String()
+
+		result["HTTPCertificateNotAfter"] = certificate.NotAfter.UTC().Format(time.RFC3339)
+
+	}
+
+	healthy := statusAccepted(responseData["expectedStatus"], response.StatusCode)
+
+	if !healthy {
+
+		errorData["status_code_error"] = fmt.Sprintf("Unexpected status code %d", response.StatusCode)
+
+	}
+
+	if keyword, ok := responseData["keyword"].(string); ok && keyword != "" {
+
+		matched := strings.Contains(string(body), keyword)
+
+		result["HTTPKeywordMatch"] = matched
+
+		if !matched {
+
+			healthy = false
+
+			errorData["keyword_error"] = "Keyword not found in response body"
+
+		}
+
+	}
+
+	if bodyPattern != nil {
+
+		matched := bodyPattern.Match(body)
+
+		result["HTTPBodyMatch"] = matched
+
+		if !matched {
+
+			healthy = false
+
+			errorData["body_match_error"] = "Response body does not match bodyRegex"
+
+		}
+
+	}
+
+	responseData["result"] = result
+
+	if !healthy {
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	responseData["errors"] = errorData
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+statusAccepted reports whether code is one of the expected status codes, which may be a single number or a list.
+Without expected codes any status below 400 is accepted.
+*/
+func statusAccepted(expected interface{}, code int) bool {
+
+	switch value := expected.(type) {
+
+	case float64:
+
+		return int(value) == code
+
+	case []interface{}:
+
+		for _, item := range value {
+
+			if number, ok := item.(float64); ok && int(number) == code {
+
+				return true
+
+			}
+
+		}
+
+		return false
+
+	default:
+
+		return code < 400
+
+	}
+
+}
+
+/*
+HandleProvisioning processes a discovery or provisioning request for the http system type.
+
+Parameters:
+- responseData: The request map, including SystemType and url.
+
+Returns:
+- A JSON string indicating the result of the check.
+*/
+func HandleProvisioning(responseData map[string]interface{}) string {
+
+	systemType, _ := responseData["SystemType"].(string)
+
+	if systemType != SystemTypeHTTP {
+
+		errorData, exists := responseData["errors"].(map[string]interface{})
+
+		if !exists {
+
+			errorData = make(map[string]interface{})
+
+		}
+
+		errorData["provisionError"] = "Unknown provision type"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	logInstance.LogInfo("Running HTTP check for URL: " + redactedURL(responseData["url"]))
+
+	return check(responseData)
+
+}
+
+/*
+redactedURL returns the url of a request with the password of its userinfo masked, for logging.
+*/
+func redactedURL(value interface{}) string {
+
+	rawURL, _ := value.(string)
+
+	target, err := url.Parse(rawURL)
+
+	if err != nil {
+
+		return "(invalid URL)"
+
+	}
+
+	return target.Redacted()
+
+}
+
+func intField(responseData map[string]interface{}, name string, fallback int) int {
+
+	if value, ok := responseData[name].(float64); ok {
+
+		return int(value)
+
+	}
+
+	return fallback
+
+}
+
+func milliseconds(duration time.Duration) float64 {
+
+	return math.Round(float64(duration)/float64(time.Millisecond)*100) / 100
+
+}
+
+func errorResponse(responseData map[string]interface{}, errorData map[string]interface{}) string {
+
+	responseData["errors"] = errorData
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
@@ -0,0 +1,557 @@
+package ping
+
+import (
+	"NMS/src/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	SystemTypePing  = "ping"
+	DefaultCount    = 4
+	DefaultTimeout  = 1000 // milliseconds
+	DefaultInterval = 200  // milliseconds
+	MaxCount        = 100
+	protocolICMP    = 1
+	protocolICMPv6  = 58
+)
+
+var (
+	logInstance = util.InitializeLogger()
+
+	// errSocket is returned when the engine cannot open an ICMP socket, e.g. without CAP_NET_RAW or with
+	// net.ipv4.ping_group_range excluding its group, so no probe was sent.
+	errSocket = errors.New("failed to open ICMP socket")
+)
+
+/*
+probeStats summarizes the round-trip times of a series of probes.
+*/
+type probeStats struct {
+	sent int
+	rtts []time.Duration
+}
+
+func (p probeStats) toResult(prefix string, result map[string]interface{}) {
+
+	received := len(p.rtts)
+
+	result[prefix+"Sent"] = p.sent
+
+	result[prefix+"Received"] = received
+
+	result[prefix+"PacketLossPercent"] = round(float64(p.sent-received) * 100 / float64(p.sent))
+
+	if received == 0 {
+
+		return
+
+	}
+
+	minimum, maximum, total, jitter := p.rtts[0], p.rtts[0], time.Duration(0), 0.0
+
+	for i, rtt := range p.rtts {
+
+		total += rtt
+
+		if rtt < minimum {
+
+			minimum = rtt
+
+		}
+
+		if rtt > maximum {
+
+			maximum = rtt
+
+		}
+
+		if i > 0 {
+
+			jitter += math.Abs(milliseconds(rtt) - milliseconds(p.rtts[i-1]))
+
+		}
+
+	}
+
+	result[prefix+"LatencyMinMs"] = round(milliseconds(minimum))
+
+	result[prefix+"LatencyAvgMs"] = round(milliseconds(total) / float64(received))
+
+	result[prefix+"LatencyMaxMs"] = round(milliseconds(maximum))
+
+	if received > 1 {
+
+		result[prefix+"JitterMs"] = round(jitter / float64(received-1))
+
+	} else {
+
+		result[prefix+"JitterMs"] = 0.0
+
+	}
+
+}
+
+/*
+pingICMP sends count ICMP echo requests to ip and collects the round-trip times of the replies.
+An unprivileged datagram socket is used where the system allows it, falling back to a raw socket.
+A raw socket receives the echo replies of every ping of the engine, so each call uses its own random ID and
+only replies from ip are counted.
+*/
+func pingICMP(ip net.IP, count int, timeout time.Duration, interval time.Duration) (probeStats, error) {
+
+	stats := probeStats{}
+
+	network, address, protocol := "udp4", "0.0.0.0", protocolICMP
+
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+
+	if ip.To4() == nil {
+
+		network, address, protocol = "udp6", "::", protocolICMPv6
+
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+
+	}
+
+	privileged := false
+
+	connection, err := icmp.ListenPacket(network, address)
+
+	if err != nil {
+
+		rawNetwork := "ip4:icmp"
+
+		if protocol == protocolICMPv6 {
+
+			rawNetwork = "ip6:ipv6-icmp"
+
+		}
+
+		connection, err = icmp.ListenPacket(rawNetwork, address)
+
+		if err != nil {
+
+			return stats, fmt.Errorf("%w: %v", errSocket, err)
+
+		}
+
+		privileged = true
+
+	}
+
+	defer connection.Close()
+
+	var destination net.Addr = &net.UDPAddr{IP: ip}
+
+	if privileged {
+
+		destination = &net.IPAddr{IP: ip}
+
+	}
+
+	id := rand.Intn(0x10000)
+
+	buffer := make([]byte, 1500)
+
+	for seq := 1; seq <= count; seq++ {
+
+		if seq > 1 {
+
+			time.Sleep(interval)
+
+		}
+
+		message := icmp.Message{
+
+			Type: requestType,
+
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("pluginengine")},
+		}
+
+		data, err := message.Marshal(nil)
+
+		if err != nil {
+
+			return stats, err
+
+		}
+
+		sent := time.Now()
+
+		if _, err := connection.WriteTo(data, destination); err != nil {
+
+			return stats, fmt.Errorf("failed to send ICMP echo: %v", err)
+
+		}
+
+		stats.sent++
+
+		connection.SetReadDeadline(sent.Add(timeout))
+
+		for {
+
+			n, peer, err := connection.ReadFrom(buffer)
+
+			if err != nil {
+
+				break // timed out, the probe is lost
+
+			}
+
+			if !peerIP(peer).Equal(ip) {
+
+				continue
+
+			}
+
+			reply, err := icmp.ParseMessage(protocol, buffer[:n])
+
+			if err != nil || reply.Type != replyType {
+
+				continue
+
+			}
+
+			echo, ok := reply.Body.(*icmp.Echo)
+
+			// the kernel rewrites the ID of unprivileged echo requests, so only raw sockets can match on it
+			if !ok || echo.Seq != seq || (privileged && echo.ID != id) {
+
+				continue
+
+			}
+
+			stats.rtts = append(stats.rtts, time.Since(sent))
+
+			break
+
+		}
+
+	}
+
+	return stats, nil
+
+}
+
+/*
+peerIP returns the IP address of the sender of an ICMP message, received on a datagram or a raw socket.
+*/
+func peerIP(peer net.Addr) net.IP {
+
+	switch address := peer.(type) {
+
+	case *net.UDPAddr:
+
+		return address.IP
+
+	case *net.IPAddr:
+
+		return address.IP
+
+	}
+
+	return nil
+
+}
+
+/*
+pingTCP opens count TCP connections to ip:port and collects the connect times.
+*/
+func pingTCP(ip net.IP, port int, count int, timeout time.Duration, interval time.Duration) (probeStats, error) {
+
+	stats := probeStats{}
+
+	var lastErr error
+
+	for i := 0; i < count; i++ {
+
+		if i > 0 {
+
+			time.Sleep(interval)
+
+		}
+
+		started := time.Now()
+
+		connection, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), timeout)
+
+		stats.sent++
+
+		if err != nil {
+
+			lastErr = err
+
+			continue
+
+		}
+
+		stats.rtts = append(stats.rtts, time.Since(started))
+
+		connection.Close()
+
+	}
+
+	if len(stats.rtts) == 0 && lastErr != nil {
+
+		return stats, lastErr
+
+	}
+
+	return stats, nil
+
+}
+
+/*
+check runs the ICMP echo and TCP connect checks of a ping request.
+
+Parameters:
+- responseData: The request map. It should include ip and may include:
+  - count: The number of probes per check (default 4).
+  - timeout: The timeout of a probe in milliseconds (default 1000).
+  - interval: The delay between probes in milliseconds (default 200).
+  - ports: TCP ports to check with connect probes.
+  - icmp: false to skip the ICMP check.
+
+Returns:
+- A JSON string with the packet loss, latency min/avg/max and jitter of every check in result.
+The request fails when no check got a reply, with the unreachable error class only if probes were sent: an ICMP
+socket the engine cannot open is reported with the local error class instead.
+*/
+func check(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	host, ok := responseData["ip"].(string)
+
+	if !ok || host == "" {
+
+		errorData["missing_fields_error"] = "Missing required field: IP"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	address, err := net.ResolveIPAddr("ip", host)
+
+	if err != nil {
+
+		errorData["resolve_error"] = err.Error()
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	count := intField(responseData, "count", DefaultCount)
+
+	if count < 1 || count > MaxCount {
+
+		errorData["invalid_field_error"] = fmt.Sprintf("count must be between 1 and %d", MaxCount)
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	timeout := time.Duration(intField(responseData, "timeout", DefaultTimeout)) * time.Millisecond
+
+	interval := time.Duration(intField(responseData, "interval", DefaultInterval)) * time.Millisecond
+
+	if timeout <= 0 || interval <= 0 {
+
+		errorData["invalid_field_error"] = "timeout and interval must be greater than 0"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	result := make(map[string]interface{})
+
+	reachable, probed := false, false
+
+	if enabled, ok := responseData["icmp"].(bool); !ok || enabled {
+
+		stats, err := pingICMP(address.IP, count, timeout, interval)
+
+		if err != nil {
+
+			logInstance.LogError(fmt.Errorf("ICMP check of %s failed: %v", host, err))
+
+			errorData["icmp_error"] = err.Error()
+
+			// a probe that could not be sent to the host still says it is unreachable, a local socket failure does not
+			probed = probed || !errors.Is(err, errSocket)
+
+		} else {
+
+			stats.toResult("Ping", result)
+
+			reachable = reachable || len(stats.rtts) > 0
+
+			probed = true
+
+		}
+
+	}
+
+	ports, _ := responseData["ports"].([]interface{})
+
+	var portResults []map[string]interface{}
+
+	for _, value := range ports {
+
+		port, ok := value.(float64)
+
+		if !ok || port < 1 || port > 65535 {
+
+			errorData["invalid_field_error"] = fmt.Sprintf("invalid port %v", value)
+
+			continue
+
+		}
+
+		portResult := map[string]interface{}{"port": int(port)}
+
+		stats, err := pingTCP(address.IP, int(port), count, timeout, interval)
+
+		if err != nil {
+
+			portResult["error"] = err.Error()
+
+		}
+
+		stats.toResult("TCP", portResult)
+
+		portResults = append(portResults, portResult)
+
+		reachable = reachable || len(stats.rtts) > 0
+
+		probed = probed || stats.sent > 0
+
+	}
+
+	if portResults != nil {
+
+		result["TCPPorts"] = portResults
+
+	}
+
+	responseData["result"] = result
+
+	switch {
+
+	case !reachable && probed:
+
+		errorData["unreachable_error"] = "No reply received from host"
+
+		errorData["error_class"] = util.ErrorClassUnreachable
+
+		return errorResponse(responseData, errorData)
+
+	case !reachable && errorData["icmp_error"] != nil:
+
+		errorData["error_class"] = util.ErrorClassLocal
+
+		return errorResponse(responseData, errorData)
+
+	case !reachable:
+
+		errorData["invalid_field_error"] = "No check to run: icmp is disabled and no valid port is given"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+HandleProvisioning processes a discovery or provisioning request for the ping system type.
+
+Parameters:
+- responseData: The request map, including SystemType and ip.
+
+Returns:
+- A JSON string indicating the result of the checks.
+*/
+func HandleProvisioning(responseData map[string]interface{}) string {
+
+	systemType, _ := responseData["SystemType"].(string)
+
+	if systemType != SystemTypePing {
+
+		errorData, exists := responseData["errors"].(map[string]interface{})
+
+		if !exists {
+
+			errorData = make(map[string]interface{})
+
+		}
+
+		errorData["provisionError"] = "Unknown provision type"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	logInstance.LogInfo("Running ping checks for IP: " + fmt.Sprint(responseData["ip"]))
+
+	return check(responseData)
+
+}
+
+func intField(responseData map[string]interface{}, name string, fallback int) int {
+
+	if value, ok := responseData[name].(float64); ok {
+
+		return int(value)
+
+	}
+
+	return fallback
+
+}
+
+func milliseconds(duration time.Duration) float64 {
+
+	return float64(duration) / float64(time.Millisecond)
+
+}
+
+func round(value float64) float64 {
+
+	return math.Round(value*100) / 100
+
+}
+
+func errorResponse(responseData map[string]interface{}, errorData map[string]interface{}) string {
+
+	responseData["errors"] = errorData
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
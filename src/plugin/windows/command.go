@@ -0,0 +1,171 @@
+package windows
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+metricCommand pairs a result key with the PowerShell snippet that produces its value.
+*/
+type metricCommand struct {
+	Metric string
+	Script string
+}
+
+var commandMarker = regexp.MustCompile(`^Command-(\d+)$`)
+
+/*
+buildScript joins the prelude and the commands into a single PowerShell script.
+The output of every command is wrapped in Command-N markers so that parseCommandOutput
+can map it back to its metric.
+*/
+func buildScript(prelude string, commands []metricCommand) string {
+
+	var script strings.Builder
+
+	script.WriteString(prelude)
+
+	for i, command := range commands {
+
+		marker := "Command-" + strconv.Itoa(i+1)
+
+		script.WriteString(`echo "` + marker + `"; ` + command.Script + `; echo "` + marker + `";`)
+
+	}
+
+	return script.String()
+
+}
+
+/*
+parseCommandOutput maps the marker-delimited output of a script built by buildScript to its metrics.
+
+Parameters:
+- data: The stdout of the script.
+- commands: The commands the script was built from.
+
+Returns:
+- A map of metric names to their converted values. Commands without output are left out.
+*/
+func parseCommandOutput(data string, commands []metricCommand) map[string]interface{} {
+
+	result := make(map[string]interface{})
+
+	for metric, value := range splitCommandOutput(data, commands) {
+
+		result[metric] = convertValue(metric, value)
+
+	}
+
+	return result
+
+}
+
+/*
+splitCommandOutput maps the marker-delimited output of a script built by buildScript to the raw output
+of each command, keyed by metric. Commands without output are left out.
+*/
+func splitCommandOutput(data string, commands []metricCommand) map[string]string {
+
+	result := make(map[string]string)
+
+	lines := strings.Split(data, "\n")
+
+	var key int
+
+	var valueLines []string
+
+	store := func() {
+
+		if key > 0 && key <= len(commands) && len(valueLines) > 0 {
+
+			result[commands[key-1].Metric] = strings.Join(valueLines, "\n")
+
+		}
+
+	}
+
+	for _, line := range lines {
+
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+
+			continue
+
+		}
+
+		match := commandMarker.FindStringSubmatch(line)
+
+		if match != nil {
+
+			store()
+
+			key, _ = strconv.Atoi(match[1])
+
+			valueLines = []string{}
+
+		} else if key != 0 {
+
+			valueLines = append(valueLines, line)
+
+		}
+
+	}
+
+	store()
+
+	return result
+
+}
+
+func convertValue(systemKey, value string) interface{} {
+
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+
+		return i
+
+	}
+
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+
+		if systemKey == "SystemUpTime" && f < 0 {
+
+			return -f
+
+		}
+
+		return f
+
+	}
+
+	switch value {
+
+	case "True":
+
+		return true
+
+	case "False":
+
+		return false
+
+	}
+
+	if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+
+		var decoded interface{}
+
+		if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+
+			return decoded
+
+		}
+
+	}
+
+	return value
+
+}
@@ -0,0 +1,40 @@
+package windows
+
+import (
+	"NMS/src/util"
+	"encoding/base64"
+	"testing"
+)
+
+func TestBuiltInCommandLinesFitCmdLimit(t *testing.T) {
+
+	scripts := map[string]string{
+
+		"discovery": "hostname",
+
+		"polling": buildScript(pollingPrelude, pollingCommands),
+
+		"inventory": buildScript(inventoryPrelude, inventoryCommands),
+	}
+
+	for name, script := range scripts {
+
+		commandLine, input := util.PowerShellInvocation(script)
+
+		if len(commandLine) > util.MaxCommandLineLength {
+
+			t.Errorf("%s: command line of %d characters exceeds %d", name, len(commandLine), util.MaxCommandLineLength)
+
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(input)
+
+		if err != nil || string(decoded) != script {
+
+			t.Errorf("%s: stdin does not decode to the script (err %v)", name, err)
+
+		}
+
+	}
+
+}
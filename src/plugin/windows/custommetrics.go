@@ -0,0 +1,154 @@
+package windows
+
+import (
+	"NMS/src/util"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	customMetricErrorPrefix = "CustomMetricError: "
+	ResultTypeInt           = "int"
+	ResultTypeFloat         = "float"
+	ResultTypeBool          = "bool"
+	ResultTypeJSON          = "json"
+	ResultTypeString        = "string"
+)
+
+/*
+customMetricCommands returns the custom metrics configured for Windows and the commands that collect them.
+Each snippet runs in its own scope with errors turned into terminating ones, so a failing snippet
+reports a CustomMetricError line instead of aborting the rest of the polling script.
+*/
+func customMetricCommands() ([]util.CustomMetric, []metricCommand) {
+
+	var metrics []util.CustomMetric
+
+	var commands []metricCommand
+
+	for _, metric := range util.LoadConfig().CustomMetrics {
+
+		if metric.SystemType != SystemTypeWindows || metric.Metric == "" || metric.Script == "" {
+
+			continue
+
+		}
+
+		metrics = append(metrics, metric)
+
+		commands = append(commands, metricCommand{
+
+			Metric: metric.Metric,
+
+			Script: `try { & { $ErrorActionPreference = 'Stop'; ` + metric.Script + ` } } catch { '` + customMetricErrorPrefix + `' + $_.Exception.Message }`,
+		})
+
+	}
+
+	return metrics, commands
+
+}
+
+/*
+applyCustomMetrics converts the raw output of the custom metrics and merges it into result.
+The unit of every collected metric is stored in units; conversion and script failures are stored in errorData.
+*/
+func applyCustomMetrics(raw map[string]string, metrics []util.CustomMetric, result map[string]interface{}, units map[string]interface{}, errorData map[string]interface{}) {
+
+	failures := make(map[string]interface{})
+
+	for _, metric := range metrics {
+
+		value, ok := raw[metric.Metric]
+
+		if !ok {
+
+			failures[metric.Metric] = "No output received"
+
+			continue
+
+		}
+
+		if strings.HasPrefix(value, customMetricErrorPrefix) {
+
+			failures[metric.Metric] = strings.TrimPrefix(value, customMetricErrorPrefix)
+
+			continue
+
+		}
+
+		converted, err := convertCustomValue(metric.ResultType, value)
+
+		if err != nil {
+
+			failures[metric.Metric] = err.Error()
+
+			continue
+
+		}
+
+		result[metric.Metric] = converted
+
+		if metric.Unit != "" {
+
+			units[metric.Metric] = metric.Unit
+
+		}
+
+	}
+
+	if len(failures) > 0 {
+
+		logInstance.LogWarning(fmt.Sprintf("%d custom metrics failed", len(failures)))
+
+		errorData["custom_metric_errors"] = failures
+
+	}
+
+}
+
+/*
+convertCustomValue converts the raw output of a custom metric to its configured result type.
+An empty result type falls back to the conversion used for the built-in metrics.
+*/
+func convertCustomValue(resultType, value string) (interface{}, error) {
+
+	switch resultType {
+
+	case ResultTypeInt:
+
+		return strconv.ParseInt(value, 10, 64)
+
+	case ResultTypeFloat:
+
+		return strconv.ParseFloat(value, 64)
+
+	case ResultTypeBool:
+
+		return strconv.ParseBool(strings.ToLower(value))
+
+	case ResultTypeJSON:
+
+		var decoded interface{}
+
+		err := json.Unmarshal([]byte(value), &decoded)
+
+		return decoded, err
+
+	case ResultTypeString:
+
+		return value, nil
+
+	case "":
+
+		return convertValue("", value), nil
+
+	default:
+
+		return nil, fmt.Errorf("unknown result type %q", resultType)
+
+	}
+
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
 )
 
 const (
@@ -33,75 +32,11 @@ func discover(responseData map[string]interface{}) string {
 
 	responseData["status"] = "fail"
 
-	ip, ipOk := responseData["ip"].(string)
+	client, shell, ok := openSession(responseData, errorData)
 
-	username, usernameOk := responseData["username"].(string)
-
-	password, passwordOk := responseData["password"].(string)
-
-	port, portOk := responseData["port"].(float64) // Check if port exists and is float64
-
-	if !portOk {
-
-		port = float64(DefaultWinRMPort)
-
-	}
-
-	finalPort := int(port)
-
-	if !ipOk || !usernameOk || !passwordOk || ip == "" || username == "" || password == "" {
-
-		logInstance.LogError(fmt.Errorf("Missing required fields: IP, Username, Password"))
-
-		errorData["missing_fields_error"] = "Missing required fields: IP, Username, Password"
-
-		responseData["errors"] = errorData
-
-		jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
-
-		return string(jsonResponse)
-	}
-
-	config := util.Config{
-
-		IP: ip,
-
-		Username: username,
-
-		Password: password,
-
-		Port: finalPort,
-
-		Timeout: 30 * time.Second,
-	}
-
-	client, err := util.InitWinRMClient(config)
-
-	if err != nil {
-
-		errorData["winrm_init_error"] = fmt.Sprintf("Failed to initialize WinRM client: %v", err)
-
-		responseData["errors"] = errorData
-
-		jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
-
-		return string(jsonResponse)
-
-	}
-
-	shell, err := util.InitWinRMShell(client)
-
-	if err != nil {
-
-		logInstance.LogError(fmt.Errorf("Error creating WinRM shell: %v", err))
-
-		errorData["winrm_shell_error"] = fmt.Sprintf("Error creating WinRM shell: %v", err)
-
-		responseData["errors"] = errorData
-
-		jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+	if !ok {
 
-		return string(jsonResponse)
+		return errorResponse(responseData, errorData)
 
 	}
 
@@ -109,23 +44,25 @@ func discover(responseData map[string]interface{}) string {
 
 	command := "hostname"
 
-	output := util.ExecuteCommand(client, shell, command)
+	commandResult := util.ExecuteCommand(client, shell, command)
 
-	if output == "" {
+	if commandResult.Err == nil && strings.TrimSpace(commandResult.Stdout) == "" {
 
-		errorData["execution_error"] = "Failed to execute command or empty output received"
+		commandResult.Err = &util.CommandError{Kind: util.CommandErrorEmptyOutput}
 
-		responseData["errors"] = errorData
+	}
 
-		jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+	if commandResult.Err != nil {
 
-		return string(jsonResponse)
+		recordCommandError(errorData, commandResult)
+
+		return errorResponse(responseData, errorData)
 
 	}
 
 	logInstance.LogInfo(command + " command executed successfully")
 
-	cleanOutput := strings.TrimSpace(output)
+	cleanOutput := strings.TrimSpace(commandResult.Stdout)
 
 	responseData["result"] = map[string]string{
 
@@ -0,0 +1,159 @@
+package windows
+
+import (
+	"NMS/src/util"
+	"encoding/json"
+	"fmt"
+)
+
+/*
+runScript connects to a Windows machine using WinRM and runs an allow-listed script template.
+
+Parameters:
+- responseData: The request map. It should include ip, username, password, template and optionally parameters and port.
+- template: The script template selected by the request.
+
+Returns:
+- A JSON string with stdout, stderr, exit code and duration in result, or the errors encountered.
+*/
+func runScript(responseData map[string]interface{}, template util.ScriptTemplate) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	parameters, _ := responseData["parameters"].(map[string]interface{})
+
+	script, err := util.RenderScriptTemplate(template, parameters, util.BindPowerShellParameters)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("Failed to render script template: %v", err))
+
+		errorData["template_error"] = err.Error()
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	client, shell, ok := openSession(responseData, errorData)
+
+	if !ok {
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	defer util.CloseWinRMShell(shell)
+
+	commandResult := util.ExecuteCommand(client, shell, script)
+
+	responseData["result"] = map[string]interface{}{
+
+		"stdout": commandResult.Stdout,
+
+		"stderr": commandResult.Stderr,
+
+		"exitCode": commandResult.ExitCode,
+
+		"durationMs": commandResult.Duration.Milliseconds(),
+	}
+
+	if commandResult.Err != nil {
+
+		recordCommandError(errorData, commandResult)
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+HandleExecute processes an execute request. Only scripts allow-listed in the scriptTemplates section
+of the configuration can be run, selected by the template field of the request. The request is retried after a
+transient failure only when it sets retry to true, for scripts that are safe to run twice.
+
+Parameters:
+- responseData: The request map, including SystemType, template, parameters, ip, username, password and retry.
+
+Returns:
+- A JSON string indicating the result of the execution.
+*/
+func HandleExecute(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	systemType, ok := responseData["SystemType"].(string)
+
+	if !ok {
+
+		logInstance.LogInfo("Missing or invalid SystemType in execute request")
+
+		errorData["executeError"] = "Invalid or missing SystemType"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	templateName, ok := responseData["template"].(string)
+
+	if !ok || templateName == "" {
+
+		logInstance.LogInfo("Missing or invalid template in execute request")
+
+		errorData["executeError"] = "Invalid or missing template"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	template, ok := util.LoadConfig().ScriptTemplates[templateName]
+
+	if !ok || template.SystemType != systemType {
+
+		logInstance.LogWarning("Rejected execute request for template not in allow-list: " + templateName)
+
+		errorData["executeError"] = "Template is not allowed for this system type"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	switch systemType {
+
+	case SystemTypeWindows:
+
+		logInstance.LogInfo("Executing template " + templateName + " for IP: " + fmt.Sprint(responseData["ip"]))
+
+		return runScript(responseData, template)
+
+	default:
+
+		logInstance.LogInfo("Received unknown execute type: " + systemType)
+
+		errorData["executeError"] = "Unknown execute type"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+}
@@ -0,0 +1,155 @@
+package windows
+
+import (
+	"NMS/src/util"
+	"encoding/json"
+	"fmt"
+)
+
+/*
+inventoryPrelude loads the CIM instances shared by the inventory commands.
+*/
+const inventoryPrelude = `$os = Get-CimInstance Win32_OperatingSystem;$cs = Get-CimInstance Win32_ComputerSystem;$bios = Get-CimInstance Win32_BIOS;$processor = Get-CimInstance Win32_Processor | Select-Object -First 1;$ntVersion = Get-ItemProperty 'HKLM:\SOFTWARE\Microsoft\Windows NT\CurrentVersion';`
+
+/*
+inventoryCommands lists the static hardware and software data collected by an inventory request.
+List values are emitted as compressed JSON and decoded by convertValue.
+*/
+var inventoryCommands = []metricCommand{
+	{"SystemHostName", `$env:COMPUTERNAME`},
+	{"SystemOSVersion", `$os.Caption`},
+	{"SystemOSVersionNumber", `$os.Version`},
+	{"SystemOSBuild", `$os.BuildNumber`},
+	{"SystemOSPatchLevel", `$ntVersion.UBR`},
+	{"SystemOSDisplayVersion", `$ntVersion.DisplayVersion`},
+	{"SystemOSArchitecture", `$os.OSArchitecture`},
+	{"SystemOSInstallDate", `$os.InstallDate.ToString('s')`},
+	{"SystemHotfixes", `ConvertTo-Json -Compress -InputObject @(Get-HotFix | Select-Object HotFixID, Description, @{n='InstalledOn';e={if ($_.InstalledOn) { $_.InstalledOn.ToString('yyyy-MM-dd') }}})`},
+	{"SystemInstalledSoftware", `ConvertTo-Json -Compress -InputObject @(Get-ItemProperty 'HKLM:\SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall\*', 'HKLM:\SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall\*' -ErrorAction SilentlyContinue | Where-Object { $_.DisplayName } | Select-Object DisplayName, DisplayVersion, Publisher | Sort-Object DisplayName -Unique)`},
+	{"SystemBIOSManufacturer", `$bios.Manufacturer`},
+	{"SystemBIOSVersion", `$bios.SMBIOSBIOSVersion`},
+	{"SystemBIOSReleaseDate", `$bios.ReleaseDate.ToString('yyyy-MM-dd')`},
+	{"SystemVendor", `$cs.Manufacturer`},
+	{"SystemModel", `$cs.Model`},
+	{"SystemSerialNumber", `$bios.SerialNumber`},
+	{"SystemCPUModel", `$processor.Name`},
+	{"SystemMemoryModules", `ConvertTo-Json -Compress -InputObject @(Get-CimInstance Win32_PhysicalMemory | Select-Object BankLabel, DeviceLocator, Manufacturer, PartNumber, Capacity, Speed)`},
+	{"SystemNetworkInterfaces", `ConvertTo-Json -Compress -InputObject @(Get-CimInstance Win32_NetworkAdapterConfiguration -Filter 'IPEnabled = True' | Select-Object Description, MACAddress, IPAddress, IPSubnet, DefaultIPGateway, DHCPEnabled)`},
+	{"SystemDomain", `$cs.Domain`},
+	{"SystemPartOfDomain", `$cs.PartOfDomain`},
+	{"SystemDomainRole", `$cs.DomainRole`},
+}
+
+/*
+collectInventory connects to a Windows machine using WinRM and collects its hardware and software inventory:
+OS version, build and patch level, installed hotfixes and software, BIOS, manufacturer, model, serial number,
+CPU model, memory modules, network interfaces and domain membership.
+
+Parameters:
+- responseData: The request map. It should include ip, username, password and optionally port.
+
+Returns:
+- A JSON string with the inventory in result, or the errors encountered.
+*/
+func collectInventory(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	client, shell, ok := openSession(responseData, errorData)
+
+	if !ok {
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	defer util.CloseWinRMShell(shell)
+
+	commandResult := util.ExecuteCommand(client, shell, buildScript(inventoryPrelude, inventoryCommands))
+
+	if commandResult.Err != nil {
+
+		recordCommandError(errorData, commandResult)
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	logInstance.LogInfo("Inventory script executed successfully")
+
+	responseData["result"] = parseCommandOutput(commandResult.Stdout, inventoryCommands)
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+HandleInventory processes an inventory request for a specified system type.
+
+Parameters:
+- responseData: The request map, including SystemType, ip, username and password.
+
+Returns:
+- A JSON string indicating the result of the inventory collection.
+*/
+func HandleInventory(responseData map[string]interface{}) string {
+
+	systemType, ok := responseData["SystemType"].(string)
+
+	if !ok {
+
+		logInstance.LogInfo("Missing or invalid SystemType in inventory request")
+
+		errorData, exists := responseData["errors"].(map[string]interface{})
+
+		if !exists {
+
+			errorData = make(map[string]interface{})
+
+		}
+
+		errorData["inventoryError"] = "Invalid or missing SystemType"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	switch systemType {
+
+	case SystemTypeWindows:
+
+		logInstance.LogInfo("Collecting Windows inventory for IP: " + fmt.Sprint(responseData["ip"]))
+
+		return collectInventory(responseData)
+
+	default:
+
+		logInstance.LogInfo("Received unknown inventory type: " + systemType)
+
+		errorData, exists := responseData["errors"].(map[string]interface{})
+
+		if !exists {
+
+			errorData = make(map[string]interface{})
+
+		}
+
+		errorData["inventoryError"] = "Unknown inventory type"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+}
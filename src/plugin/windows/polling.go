@@ -4,268 +4,330 @@ import (
 	"NMS/src/util"
 	"encoding/json"
 	"fmt"
-	"regexp"
-	"strconv"
-	"strings"
+	"math"
 	"time"
 )
 
-
 var (
 	logInstance = util.InitializeLogger()
 )
 
+/*
+pollingPrelude loads the CIM instances and counters shared by the polling commands.
+*/
+const pollingPrelude = `$os = Get-CimInstance Win32_OperatingSystem;$processor=Get-CimInstance Win32_Processor;$memory = Get-CimInstance Win32_PerfFormattedData_PerfOS_Memory;$disk = Get-CimInstance Win32_LogicalDisk;$cpuPerf = Get-Counter '\Processor(_Total)\% Idle Time' | Select-Object -ExpandProperty CounterSamples | Select-Object -ExpandProperty CookedValue;`
 
 /*
-Start initializes a WinRM client, executes a PowerShell script to fetch system metrics, and populates a map with the results.
+pollingCommands lists the high-frequency metrics collected on every provisioning request.
+Static data such as the OS caption, vendor and serial number is collected by the inventory request instead.
+*/
+var pollingCommands = []metricCommand{
+	{"SystemHostName", `$env:COMPUTERNAME`},
+	{"SystemUpTime", `($os.LastBootUpTime - (Get-Date)).TotalSeconds`},
+	{"SystemDiskUsedBytes", `($disk | Measure-Object -Property Size -Sum).Sum - ($disk | Measure-Object -Property FreeSpace -Sum).Sum`},
+	{"SystemPhysicalProcessors", `(Get-CimInstance Win32_ComputerSystem).NumberOfProcessors`},
+	{"SystemCPUCores", `($processor | Measure-Object -Property NumberOfCores -Sum).Sum`},
+	{"SystemLogicalProcessors", `(Get-CimInstance Win32_ComputerSystem).NumberOfLogicalProcessors`},
+	{"SystemRunningProcesses", `(Get-Process | Measure-Object).Count`},
+	{"SystemCPUIdlePercent", `[math]::Round($cpuPerf, 2)`},
+	{"SystemMemoryFreePercent", `[math]::Round(($os.FreePhysicalMemory / $os.TotalVisibleMemorySize) * 100, 2)`},
+	{"SystemCacheMemoryBytes", `$memory.CacheBytes`},
+	{"SystemMemoryUsedPercent", `[math]::Round((($os.TotalVisibleMemorySize - $os.FreePhysicalMemory) / $os.TotalVisibleMemorySize) * 100, 2)`},
+	{"SystemMemoryAvailableBytes", `$os.FreePhysicalMemory * 1024`},
+	{"SystemCPUDescription", `$processor.Name`},
+	{"SystemCPUInterruptPerSec", `(Get-Counter '\Processor(_Total)\Interrupts/sec').CounterSamples.CookedValue`},
+	{"SystemMemoryCommittedBytes", `($os.TotalVirtualMemorySize - $os.FreeVirtualMemory) * 1024`},
+	{"SystemDiskFreePercent", `[math]::Round(($disk | Measure-Object -Property FreeSpace -Sum).Sum * 100 / ($disk | Measure-Object -Property Size -Sum).Sum, 2)`},
+	{"SystemDiskUsedPercent", `[math]::Round((($disk | Measure-Object -Property Size -Sum).Sum - ($disk | Measure-Object -Property FreeSpace -Sum).Sum) * 100 / ($disk | Measure-Object -Property Size -Sum).Sum, 2)`},
+	{"SystemNetworkTCPConnections", `(Get-CimInstance Win32_PerfRawData_Tcpip_TCPv4).ConnectionsEstablished`},
+	{"SystemContextSwitchesPerSec", `(Get-CimInstance Win32_PerfFormattedData_PerfOS_System).ContextSwitchesPerSec`},
+	{"SystemDiskCapacityBytes", `($disk | Measure-Object -Property Size -Sum).Sum`},
+	{"SystemCPUType", `$processor.Name`},
+	{"SystemName", `$env:COMPUTERNAME`},
+	{"SystemThreads", `(Get-Process | ForEach-Object { $_.Threads.Count } | Measure-Object -Sum).Sum`},
+	{"SystemProcessorQueueLength", `(Get-CimInstance Win32_PerfRawData_PerfOS_System).ProcessorQueueLength`},
+	{"SystemCPUUserPercent", `(Get-Counter '\Processor(_Total)\% User Time').CounterSamples.CookedValue`},
+	{"SystemCPUPercent", `(Get-Counter '\Processor(_Total)\% Processor Time').CounterSamples.CookedValue`},
+	{"SystemMemoryInstalledBytes", `$os.TotalVisibleMemorySize * 1024`},
+	{"SystemMemoryUsedBytes", `(($os.TotalVisibleMemorySize - $os.FreePhysicalMemory) * 1024)`},
+	{"SystemDiskFreeBytes", `($disk | Measure-Object -Property FreeSpace -Sum).Sum`},
+	{"SystemMemoryFreeBytes", `$os.FreePhysicalMemory * 1024`},
+	{"SystemNetworkTCPSegments", `(Get-CimInstance Win32_PerfRawData_Tcpip_TCPv4).SegmentsPersec`},
+	{"SystemNetworkTCPConnectionFailures", `(Get-CimInstance Win32_PerfRawData_Tcpip_TCPv4).ConnectionFailures`},
+	{"SystemNetworkTCPConnectionResets", `(Get-CimInstance Win32_PerfRawData_Tcpip_TCPv4).ConnectionsReset`},
+	{"SystemContextSwitches", `(Get-CimInstance Win32_PerfRawData_PerfOS_System).ContextSwitchesPersec`},
+}
 
-Parameters:
-- ip: The IP address of the target Windows machine.
-- username: The username for authentication.
-- password: The password for authentication.
+/*
+pollingCounterMetrics are the polling metrics that are cumulative counters. For each of them the delta and
+per-second rate since the previous poll of the host are added to the result as <metric>Delta and <metric>Rate.
+ConnectionsEstablished and ProcessorQueueLength come from raw classes too, but are instantaneous counts and
+are reported as they are.
+*/
+var pollingCounterMetrics = []string{
+	"SystemNetworkTCPSegments",
+	"SystemNetworkTCPConnectionFailures",
+	"SystemNetworkTCPConnectionResets",
+	"SystemContextSwitches",
+}
 
-Returns:
-- A map containing the system metrics.
+var counterTracker = util.NewRateTracker()
+
+/*
+pollingMetricGroups assigns the polling metrics to the groups a request can select through metricGroups.
+Custom metrics belong to the custom group.
 */
-func start(responseData map[string]interface{}) string {
+var pollingMetricGroups = map[string][]string{
+	"system":  {"SystemHostName", "SystemName", "SystemUpTime", "SystemRunningProcesses", "SystemThreads", "SystemContextSwitchesPerSec", "SystemContextSwitches"},
+	"cpu":     {"SystemPhysicalProcessors", "SystemCPUCores", "SystemLogicalProcessors", "SystemCPUIdlePercent", "SystemCPUDescription", "SystemCPUInterruptPerSec", "SystemCPUType", "SystemProcessorQueueLength", "SystemCPUUserPercent", "SystemCPUPercent"},
+	"memory":  {"SystemMemoryFreePercent", "SystemCacheMemoryBytes", "SystemMemoryUsedPercent", "SystemMemoryAvailableBytes", "SystemMemoryCommittedBytes", "SystemMemoryInstalledBytes", "SystemMemoryUsedBytes", "SystemMemoryFreeBytes"},
+	"disk":    {"SystemDiskUsedBytes", "SystemDiskFreePercent", "SystemDiskUsedPercent", "SystemDiskCapacityBytes", "SystemDiskFreeBytes"},
+	"network": {"SystemNetworkTCPConnections", "SystemNetworkTCPSegments", "SystemNetworkTCPConnectionFailures", "SystemNetworkTCPConnectionResets"},
+	"custom":  {},
+}
 
+/*
+selectPollingCommands returns the polling commands of the metric groups requested in responseData["metricGroups"],
+and whether custom metrics should be collected. All commands are returned when no groups are requested.
+SystemUpTime is collected with any counter metric, as reboots that reset the counters are detected through it.
+An unknown group is reported through the error.
+*/
+func selectPollingCommands(responseData map[string]interface{}) ([]metricCommand, bool, error) {
 
-	errorData, exists := responseData["errors"].(map[string]interface{})
+	groups, ok := responseData["metricGroups"].([]interface{})
 
-	if !exists {
+	if !ok || len(groups) == 0 {
 
-		errorData = make(map[string]interface{})
+		return pollingCommands, true, nil
 
 	}
 
-	responseData["status"] = "fail"
+	selected := make(map[string]bool)
 
-	ip, ipOk := responseData["ip"].(string)
+	includeCustom := false
 
-	username, usernameOk := responseData["username"].(string)
+	for _, group := range groups {
 
-	password, passwordOk := responseData["password"].(string)
-	port, portOk := responseData["port"].(float64) // Check if port exists and is float64
+		name := fmt.Sprint(group)
 
-	if !portOk {
+		metrics, exists := pollingMetricGroups[name]
 
-		port = float64(DefaultWinRMPort)
+		if !exists {
 
-	}
+			return nil, false, fmt.Errorf("unknown metric group %q", name)
 
-	finalPort := int(port)
+		}
 
-	if !ipOk || !usernameOk || !passwordOk || ip == "" || username == "" || password == "" {
+		if name == "custom" {
 
-		logInstance.LogError(fmt.Errorf("Missing required fields: IP, Username, Password"))
+			includeCustom = true
 
-		errorData["missing_fields_error"] = "Missing required fields: IP, Username, Password"
+		}
 
-		responseData["errors"] = errorData
+		for _, metric := range metrics {
 
-		jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+			selected[metric] = true
 
-		return string(jsonResponse)
+		}
 
 	}
 
+	for _, metric := range pollingCounterMetrics {
 
-	config := util.Config{
+		if selected[metric] {
 
-		IP:       ip,
+			selected["SystemUpTime"] = true
 
-		Username: username,
+		}
 
-		Password: password,
+	}
 
-		Port:finalPort,
+	var commands []metricCommand
 
-		Timeout:  30 * time.Second,
+	for _, command := range pollingCommands {
 
-	}
+		if selected[command.Metric] {
 
+			commands = append(commands, command)
 
-	client, err := util.InitWinRMClient(config)
+		}
 
-	if err != nil {
+	}
 
-		logInstance.LogError(fmt.Errorf("Failed to initialize WinRM client: %v", err))
+	return commands, includeCustom, nil
 
-		errorData["winrm_init_error"] = fmt.Sprintf("Failed to initialize WinRM client: %v", err)
+}
 
-		responseData["errors"] = errorData
+/*
+applyCounterRates adds the delta and per-second rate of the counter metrics in result, computed against the
+previous poll of host. Rates are skipped on the first poll and after a reboot, detected through SystemUpTime.
+*/
+func applyCounterRates(host string, result map[string]interface{}, now time.Time) {
 
-		jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+	if uptime, ok := toFloat(result["SystemUpTime"]); ok {
 
-		return string(jsonResponse)
+		if counterTracker.ObserveUptime(host, uptime, now) {
 
-	}
+			logInstance.LogInfo("Reboot detected, counter samples reset for IP: " + host)
 
-	logInstance.LogInfo("Initialized WinRM client successfully")
+		}
 
-	shell, err := util.InitWinRMShell(client)
+	}
 
-	if err != nil {
+	for _, metric := range pollingCounterMetrics {
 
-		logInstance.LogError(fmt.Errorf("Error creating WinRM shell: %v", err))
+		value, ok := toFloat(result[metric])
 
-		errorData["winrm_shell_error"] = fmt.Sprintf("Error creating WinRM shell: %v", err)
+		if !ok {
 
-		responseData["errors"] = errorData
+			continue
 
-		jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+		}
 
-		return string(jsonResponse)
+		rate, ok := counterTracker.Observe(host, metric, value, now)
 
-	}
+		if rate.Reset {
 
-	logInstance.LogInfo("Initialized WinRM shell successfully")
+			logInstance.LogInfo(fmt.Sprintf("Counter %s reset for IP: %s", metric, host))
 
-	defer util.CloseWinRMShell(shell)
+		}
 
-	psScript := `$os = Get-CimInstance Win32_OperatingSystem;$processor=Get-CimInstance Win32_Processor;$memory = Get-CimInstance Win32_PerfFormattedData_PerfOS_Memory;$disk = Get-CimInstance Win32_LogicalDisk;$bios = Get-CimInstance Win32_BIOS;$cpuPerf = Get-Counter '\Processor(_Total)\% Idle Time' | Select-Object -ExpandProperty CounterSamples | Select-Object -ExpandProperty CookedValue;echo "Command-1"; $env:COMPUTERNAME; echo "Command-1";echo "Command-2"; ($os.LastBootUpTime - (Get-Date)).TotalSeconds; echo "Command-2";echo "Command-3"; ($disk | Measure-Object -Property Size -Sum).Sum - ($disk | Measure-Object -Property FreeSpace -Sum).Sum; echo "Command-3";echo "Command-4"; (Get-CimInstance Win32_ComputerSystem).NumberOfProcessors; echo "Command-4";echo "Command-5"; ($processor | Measure-Object -Property NumberOfCores -Sum).Sum; echo "Command-5";echo "Command-6";(Get-CimInstance Win32_ComputerSystem).NumberOfLogicalProcessors; echo "Command-6";echo "Command-7"; (Get-Process | Measure-Object).Count; echo "Command-7";echo "Command-8"; $os.Caption; echo "Command-8";echo "Command-9"; (Get-CimInstance Win32_ComputerSystem).Manufacturer; echo "Command-9";echo "Command-10"; $bios.SerialNumber; echo "Command-10";echo "Command-11";math]::Round($cpuPerf, 2); echo "Command-11";echo "Command-12"; [math]::Round(($os.FreePhysicalMemory / $os.TotalVisibleMemorySize) * 100, 2);echo "Command-12";echo "Command-13";$memory.CacheBytes; echo "Command-13";echo "Command-14"; [math]::Round((($os.TotalVisibleMemorySize - $os.FreePhysicalMemory) / $os.TotalVisibleMemorySize) * 100, 2);echo "Command-14";echo "Command-15"; $os.FreePhysicalMemory * 1024;echo "Command-15";echo "Command-16"; $processor.Name; echo "Command-16";echo "Command-17"; (Get-Counter '\Processor(_Total)\Interrupts/sec').CounterSamples.CookedValue; echo "Command-17";echo "Command-18"; ($os.TotalVirtualMemorySize - $os.FreeVirtualMemory) * 1024; echo "Command-18";echo "Command-19"; [math]::Round(($disk | Measure-Object -Property FreeSpace -Sum).Sum * 100 / ($disk | Measure-Object -Property Size -Sum).Sum, 2); echo "Command-19";echo "Command-20"; [math]::Round((($disk | Measure-Object -Property Size -Sum).Sum - ($disk | Measure-Object -Property FreeSpace -Sum).Sum) * 100 / ($disk | Measure-Object -Property Size -Sum).Sum, 2); echo "Command-20";echo "Command-21"; (Get-CimInstance Win32_PerfRawData_Tcpip_TCPv4).ConnectionsEstablished; echo "Command-21";echo "Command-22"; (Get-CimInstance Win32_PerfFormattedData_PerfOS_System).ContextSwitchesPerSec; echo "Command-22";echo "Command-23"; ($disk | Measure-Object -Property Size -Sum).Sum; echo "Command-23";echo "Command-24"; $processor.Name; echo "Command-24";echo "Command-25"; $env:COMPUTERNAME; echo "Command-25";echo "Command-26"; (Get-Process | ForEach-Object { $_.Threads.Count } | Measure-Object -Sum).Sum; echo "Command-26";echo "Command-27"; (Get-CimInstance Win32_PerfRawData_PerfOS_System).ProcessorQueueLength; echo "Command-27";echo "Command-28"; (Get-Counter '\Processor(_Total)\% User Time').CounterSamples.CookedValue; echo "Command-28";echo "Command-29"; (Get-Counter '\Processor(_Total)\% Processor Time').CounterSamples.CookedValue; echo "Command-29";echo "Command-30"; $os.TotalVisibleMemorySize * 1024; echo "Command-30";echo "Command-31"; (($os.TotalVisibleMemorySize - $os.FreePhysicalMemory) * 1024); echo "Command-31";echo "Command-32"; ($disk | Measure-Object -Property FreeSpace -Sum).Sum; echo "Command-32";echo "Command-33"; $os.FreePhysicalMemory * 1024; echo "Command-33"`
+		if !ok {
 
-	output := util.ExecuteCommand(client, shell, strings.ReplaceAll(psScript, "\n\t", ""))
+			continue
 
-	logInstance.LogInfo("PowerShell script executed successfully")
+		}
 
-	maps := parseCommandOutput(output)
+		if rate.Wrapped {
 
-	responseData["result"] = maps
+			logInstance.LogInfo(fmt.Sprintf("Counter %s wrapped for IP: %s", metric, host))
 
-	responseData["status"] = "success"
+		}
 
-	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+		result[metric+"Delta"] = rate.Delta
 
-	return string(jsonResponse)
+		result[metric+"Rate"] = math.Round(rate.PerSec*100) / 100
+
+	}
 
 }
 
-func parseCommandOutput(data string) map[string]interface{} {
-	mapping := map[string]string{
-		"Command-1":  "SystemHostName",
-		"Command-2":  "SystemUpTime",
-		"Command-3":  "SystemDiskUsedBytes",
-		"Command-4":  "SystemPhysicalProcessors",
-		"Command-5":  "SystemCPUCores",
-		"Command-6":  "SystemLogicalProcessors",
-		"Command-7":  "SystemRunningProcesses",
-		"Command-8":  "SystemOSVersion",
-		"Command-9":  "SystemVendor",
-		"Command-10": "SystemSerialNumber",
-		"Command-11": "SystemCPUIdlePercent",
-		"Command-12": "SystemMemoryFreePercent",
-		"Command-13": "SystemCacheMemoryBytes",
-		"Command-14": "SystemMemoryUsedPercent",
-		"Command-15": "SystemMemoryAvailableBytes",
-		"Command-16": "SystemCPUDescription",
-		"Command-17": "SystemCPUInterruptPerSec",
-		"Command-18": "SystemMemoryCommittedBytes",
-		"Command-19": "SystemDiskFreePercent",
-		"Command-20": "SystemDiskUsedPercent",
-		"Command-21": "SystemNetworkTCPConnections",
-		"Command-22": "SystemContextSwitchesPerSec",
-		"Command-23": "SystemDiskCapacityBytes",
-		"Command-24": "SystemCPUType",
-		"Command-25": "SystemName",
-		"Command-26": "SystemThreads",
-		"Command-27": "SystemProcessorQueueLength",
-		"Command-28": "SystemCPUUserPercent",
-		"Command-29": "SystemCPUPercent",
-		"Command-30": "SystemMemoryInstalledBytes",
-		"Command-31": "SystemMemoryUsedBytes",
-		"Command-32": "SystemDiskFreeBytes",
-		"Command-33": "SystemMemoryFreeBytes",
-	}
+func toFloat(value interface{}) (float64, bool) {
 
-	result := make(map[string]interface{})
+	switch number := value.(type) {
 
-	lines := strings.Split(data, "\n")
+	case int64:
 
-	var key string
+		return float64(number), true
 
-	var valueLines []string
+	case float64:
 
-	re := regexp.MustCompile(`^Command-(\d+)$`)
+		return number, true
 
-	for _, line := range lines {
+	}
 
-		line = strings.TrimSpace(line)
+	return 0, false
 
-		if line == "" {
+}
 
-			continue
+/*
+Start initializes a WinRM client, executes a PowerShell script to fetch system metrics, and populates a map with the results.
+Custom metrics configured for Windows are collected by the same script and merged into the results.
+When metricGroups is present in the request only the metrics of those groups are collected.
 
-		}
+Parameters:
+- ip: The IP address of the target Windows machine.
+- username: The username for authentication.
+- password: The password for authentication.
 
-		match := re.FindStringSubmatch(line)
+Returns:
+- A map containing the system metrics.
+*/
+func start(responseData map[string]interface{}) string {
 
-		if match != nil {
+	errorData, exists := responseData["errors"].(map[string]interface{})
 
-			if key != "" && len(valueLines) > 0 {
+	if !exists {
 
-				if systemKey, exists := mapping[key]; exists {
+		errorData = make(map[string]interface{})
 
-					result[systemKey] = convertValue(systemKey, strings.Join(valueLines, "\n"))
+	}
 
-				}
+	responseData["status"] = "fail"
 
-			}
+	builtinCommands, includeCustom, err := selectPollingCommands(responseData)
 
-			key = "Command-" + match[1]
+	if err != nil {
 
-			valueLines = []string{}
+		logInstance.LogError(err)
 
-		} else if key != "" {
+		errorData["metric_group_error"] = err.Error()
 
-			valueLines = append(valueLines, line)
+		return errorResponse(responseData, errorData)
 
-		}
+	}
+
+	client, shell, ok := openSession(responseData, errorData)
+
+	if !ok {
+
+		return errorResponse(responseData, errorData)
 
 	}
 
-	if key != "" && len(valueLines) > 0 {
+	logInstance.LogInfo("Initialized WinRM client and shell successfully")
 
-		if systemKey, exists := mapping[key]; exists {
+	defer util.CloseWinRMShell(shell)
 
-			result[systemKey] = convertValue(systemKey, strings.Join(valueLines, "\n"))
+	var customMetrics []util.CustomMetric
 
-		}
+	var customCommands []metricCommand
 
-	}
+	if includeCustom {
 
-	return result
+		customMetrics, customCommands = customMetricCommands()
 
-}
+	}
+
+	commands := append(append([]metricCommand{}, builtinCommands...), customCommands...)
 
+	commandResult := util.ExecuteCommand(client, shell, buildScript(pollingPrelude, commands))
 
-func convertValue(systemKey, value string) interface{} {
+	if commandResult.Err != nil {
 
-	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		recordCommandError(errorData, commandResult)
 
-		return i
+		return errorResponse(responseData, errorData)
 
 	}
 
+	logInstance.LogInfo("PowerShell script executed successfully")
 
-	if f, err := strconv.ParseFloat(value, 64); err == nil {
+	result := parseCommandOutput(commandResult.Stdout, builtinCommands)
 
-		if systemKey == "SystemUpTime" && f < 0 {
+	applyCounterRates(fmt.Sprint(responseData["ip"]), result, time.Now())
 
-			return -f
+	if len(customMetrics) > 0 {
 
-		}
+		units := make(map[string]interface{})
 
-		return f
+		applyCustomMetrics(splitCommandOutput(commandResult.Stdout, commands), customMetrics, result, units, errorData)
+
+		responseData["units"] = units
+
+		responseData["errors"] = errorData
 
 	}
 
+	responseData["result"] = result
 
-	return value
+	responseData["status"] = "success"
 
-}
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
 
+	return string(jsonResponse)
 
+}
 
 /*
 handleProvisioning processes a provisioning request for a specified system type.
@@ -310,7 +372,6 @@ func HandleProvisioning(responseData map[string]interface{}) string {
 
 	}
 
-
 	switch systemType {
 
 	case SystemTypeWindows:
@@ -331,7 +392,6 @@ func HandleProvisioning(responseData map[string]interface{}) string {
 
 		}
 
-
 		errorData["provisionError"] = "Unknown provision type"
 
 		responseData["errors"] = errorData
@@ -344,7 +404,6 @@ func HandleProvisioning(responseData map[string]interface{}) string {
 
 	logInstance.LogInfo("Provisioning completed for:" + systemType)
 
-
 	result, ok := responseEntity.(string)
 
 	if !ok {
@@ -354,4 +413,4 @@ func HandleProvisioning(responseData map[string]interface{}) string {
 
 	return result
 
-}
\ No newline at end of file
+}
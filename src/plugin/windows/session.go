@@ -0,0 +1,135 @@
+package windows
+
+import (
+	"NMS/src/util"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/masterzen/winrm"
+)
+
+/*
+openSession validates the connection fields of a request and opens a WinRM client and shell for the target host.
+
+Parameters:
+- responseData: The request map. It should include ip, username, password and optionally port.
+- errorData: The error map of the response. Any failure is recorded in it.
+
+Returns:
+- The WinRM client and shell, and true when both were created successfully.
+- nil values and false otherwise. The caller is expected to return the error response.
+*/
+func openSession(responseData map[string]interface{}, errorData map[string]interface{}) (*winrm.Client, *winrm.Shell, bool) {
+
+	ip, ipOk := responseData["ip"].(string)
+
+	username, usernameOk := responseData["username"].(string)
+
+	password, passwordOk := responseData["password"].(string)
+
+	port, portOk := responseData["port"].(float64) // Check if port exists and is float64
+
+	if !portOk {
+
+		port = float64(DefaultWinRMPort)
+
+	}
+
+	if !ipOk || !usernameOk || !passwordOk || ip == "" || username == "" || password == "" {
+
+		logInstance.LogError(fmt.Errorf("Missing required fields: IP, Username, Password"))
+
+		errorData["missing_fields_error"] = "Missing required fields: IP, Username, Password"
+
+		return nil, nil, false
+
+	}
+
+	config := util.Config{
+
+		IP: ip,
+
+		Username: username,
+
+		Password: password,
+
+		Port: int(port),
+
+		Timeout: 30 * time.Second,
+	}
+
+	client, err := util.InitWinRMClient(config)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("Failed to initialize WinRM client: %v", err))
+
+		errorData["winrm_init_error"] = fmt.Sprintf("Failed to initialize WinRM client: %v", err)
+
+		errorData["error_class"] = util.ClassifyError(err)
+
+		return nil, nil, false
+
+	}
+
+	shell, err := util.InitWinRMShell(client)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("Error creating WinRM shell: %v", err))
+
+		errorData["winrm_shell_error"] = fmt.Sprintf("Error creating WinRM shell: %v", err)
+
+		errorData["error_class"] = util.ClassifyError(err)
+
+		return nil, nil, false
+
+	}
+
+	return client, shell, true
+
+}
+
+/*
+errorResponse stores errorData in responseData and returns the indented JSON response.
+*/
+func errorResponse(responseData map[string]interface{}, errorData map[string]interface{}) string {
+
+	responseData["errors"] = errorData
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+recordCommandError stores the details of a failed command in errorData: the error message and kind,
+the exit code, the stderr output and the duration of the command. Transport failures are also given an
+error_class for the retry policy.
+*/
+func recordCommandError(errorData map[string]interface{}, result util.CommandResult) {
+
+	errorData["execution_error"] = result.Err.Error()
+
+	errorData["execution_error_kind"] = result.Err.Kind
+
+	if result.Err.Kind == util.CommandErrorTransport {
+
+		errorData["error_class"] = util.ClassifyError(result.Err)
+
+	}
+
+	errorData["exit_code"] = result.ExitCode
+
+	errorData["duration_ms"] = result.Duration.Milliseconds()
+
+	if stderr := strings.TrimSpace(result.Stderr); stderr != "" {
+
+		errorData["stderr"] = stderr
+
+	}
+
+}
@@ -0,0 +1,314 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionPause  = "pause"
+	ActionResume = "resume"
+	ActionDelete = "delete"
+)
+
+/*
+HandleSchedule processes a schedule request, which registers, updates, pauses, resumes or deletes a monitor.
+
+Parameters:
+- responseData: The request map. It should include:
+  - action: One of create, update, pause, resume or delete.
+  - monitorId: The monitor to act on. Generated on create when missing.
+  - SystemType, ip, username, password, port: The target of the polls (create and update). Credentials are only
+    required by the SystemTypes that log in, and ip may be left out when parameters has a url.
+  - interval, jitter: The cadence of the polls in seconds (create and update).
+  - metricGroups: The metric groups to collect (optional).
+  - parameters: The other fields of the plugin request, e.g. url, name, ports or expected (optional).
+
+Returns:
+- A JSON string with the resulting monitor, credentials redacted.
+*/
+func HandleSchedule(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	scheduler := Instance()
+
+	if scheduler == nil {
+
+		errorData["schedule_error"] = "Scheduler is not running"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	action, _ := responseData["action"].(string)
+
+	id, _ := responseData["monitorId"].(string)
+
+	if action != ActionCreate && id == "" {
+
+		errorData["schedule_error"] = "Missing monitorId"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	var monitor Monitor
+
+	var err error
+
+	switch action {
+
+	case ActionCreate:
+
+		if id == "" {
+
+			id = newMonitorID()
+
+		}
+
+		monitor, err = parseMonitor(responseData, Monitor{ID: id})
+
+		if err == nil {
+
+			err = scheduler.Add(monitor)
+
+		}
+
+	case ActionUpdate, ActionPause, ActionResume:
+
+		current, found := scheduler.Get(id)
+
+		if !found {
+
+			err = ErrMonitorNotFound
+
+			break
+
+		}
+
+		monitor = current
+
+		if action == ActionUpdate {
+
+			monitor, err = parseMonitor(responseData, current)
+
+		} else {
+
+			monitor.Paused = action == ActionPause
+
+		}
+
+		if err == nil {
+
+			err = scheduler.Update(monitor)
+
+		}
+
+	case ActionDelete:
+
+		monitor, _ = scheduler.Get(id)
+
+		err = scheduler.Remove(id)
+
+	default:
+
+		logInstance.LogInfo("Received unknown schedule action: " + action)
+
+		errorData["schedule_error"] = "Unknown schedule action"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("schedule %s failed for monitor %s: %v", action, id, err))
+
+		errorData["schedule_error"] = err.Error()
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	responseData["monitorId"] = monitor.ID
+
+	responseData["result"] = map[string]interface{}{
+
+		"action": action,
+
+		"monitor": monitor.redacted(),
+	}
+
+	responseData["status"] = "success"
+
+	redactPassword(responseData)
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+parseMonitor applies the monitor fields present in responseData on top of base and validates the result.
+*/
+func parseMonitor(responseData map[string]interface{}, base Monitor) (Monitor, error) {
+
+	monitor := base
+
+	if value, ok := responseData["ip"].(string); ok {
+
+		monitor.IP = value
+
+	}
+
+	if value, ok := responseData["SystemType"].(string); ok {
+
+		monitor.SystemType = value
+
+	}
+
+	if value, ok := responseData["username"].(string); ok {
+
+		monitor.Username = value
+
+	}
+
+	if value, ok := responseData["password"].(string); ok {
+
+		monitor.Password = value
+
+	}
+
+	if value, ok := responseData["port"].(float64); ok {
+
+		monitor.Port = int(value)
+
+	}
+
+	if value, ok := responseData["interval"].(float64); ok {
+
+		monitor.Interval = int(value)
+
+	}
+
+	if value, ok := responseData["jitter"].(float64); ok {
+
+		monitor.Jitter = int(value)
+
+	}
+
+	if value, ok := responseData["paused"].(bool); ok {
+
+		monitor.Paused = value
+
+	}
+
+	if groups, ok := responseData["metricGroups"].([]interface{}); ok {
+
+		monitor.MetricGroups = nil
+
+		for _, group := range groups {
+
+			monitor.MetricGroups = append(monitor.MetricGroups, fmt.Sprint(group))
+
+		}
+
+	}
+
+	if parameters, ok := responseData["parameters"].(map[string]interface{}); ok {
+
+		monitor.Parameters = parameters
+
+	}
+
+	return monitor, monitor.validate()
+
+}
+
+func newMonitorID() string {
+
+	id := make([]byte, 8)
+
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+
+}
+
+/*
+redactPassword hides the password of the request so it is not echoed in the response.
+*/
+func redactPassword(responseData map[string]interface{}) {
+
+	if _, ok := responseData["password"]; ok {
+
+		responseData["password"] = "******"
+
+	}
+
+}
+
+func errorResponse(responseData map[string]interface{}, errorData map[string]interface{}) string {
+
+	redactPassword(responseData)
+
+	responseData["errors"] = errorData
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+HandleListMonitors processes a listMonitors request and returns the registered monitors with their last-run state.
+Credentials are redacted.
+*/
+func HandleListMonitors(responseData map[string]interface{}) string {
+
+	errorData, exists := responseData["errors"].(map[string]interface{})
+
+	if !exists {
+
+		errorData = make(map[string]interface{})
+
+	}
+
+	responseData["status"] = "fail"
+
+	scheduler := Instance()
+
+	if scheduler == nil {
+
+		errorData["schedule_error"] = "Scheduler is not running"
+
+		return errorResponse(responseData, errorData)
+
+	}
+
+	responseData["result"] = map[string]interface{}{
+
+		"monitors": scheduler.List(),
+	}
+
+	responseData["status"] = "success"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
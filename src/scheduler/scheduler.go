@@ -0,0 +1,554 @@
+package scheduler
+
+import (
+	"NMS/src/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MinInterval             = 1
+	RequestTypeProvisioning = "provisioning"
+)
+
+var (
+	logInstance = util.InitializeLogger()
+
+	// credentialFields lists the credentials a monitor must carry for the SystemTypes that log in to their target.
+	credentialFields = map[string][]string{
+		"windows":    {"username", "password"},
+		"postgresql": {"username"},
+		"mysql":      {"username"},
+	}
+
+	ErrMonitorNotFound = errors.New("monitor not found")
+	ErrMonitorExists   = errors.New("monitor already exists")
+)
+
+/*
+Monitor is a target registered for scheduled polling.
+Interval and Jitter are in seconds; every run is delayed by Interval plus a random value up to Jitter.
+Parameters holds the other fields of the request of the plugin, such as the url of an http check or the name and
+expected answers of a dns check.
+*/
+type Monitor struct {
+	ID           string                 `json:"monitorId"`
+	IP           string                 `json:"ip,omitempty"`
+	SystemType   string                 `json:"SystemType"`
+	Username     string                 `json:"username,omitempty"`
+	Password     string                 `json:"password,omitempty"`
+	Port         int                    `json:"port,omitempty"`
+	MetricGroups []string               `json:"metricGroups,omitempty"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	Interval     int                    `json:"interval"`
+	Jitter       int                    `json:"jitter"`
+	Paused       bool                   `json:"paused"`
+}
+
+/*
+request builds the provisioning request submitted to the worker pool on every run of the monitor.
+The parameters come first, so they cannot replace the fields the monitor sets itself.
+*/
+func (m Monitor) request() (string, error) {
+
+	request := make(map[string]interface{}, len(m.Parameters)+8)
+
+	for name, value := range m.Parameters {
+
+		request[name] = value
+
+	}
+
+	request["RequestType"] = RequestTypeProvisioning
+
+	request["SystemType"] = m.SystemType
+
+	request["monitorId"] = m.ID
+
+	for name, value := range map[string]string{"ip": m.IP, "username": m.Username, "password": m.Password} {
+
+		if value != "" {
+
+			request[name] = value
+
+		}
+
+	}
+
+	if m.Port > 0 {
+
+		request["port"] = m.Port
+
+	}
+
+	if len(m.MetricGroups) > 0 {
+
+		request["metricGroups"] = m.MetricGroups
+
+	}
+
+	data, err := json.Marshal(request)
+
+	return string(data), err
+
+}
+
+/*
+redacted returns a copy of the monitor that is safe to include in responses, with the password and the
+credentials among the parameters redacted.
+*/
+func (m Monitor) redacted() Monitor {
+
+	if m.Password != "" {
+
+		m.Password = util.RedactedValue
+
+	}
+
+	if m.Parameters != nil {
+
+		// a deep copy, as redaction changes nested maps such as headers in place
+		var parameters map[string]interface{}
+
+		data, _ := json.Marshal(m.Parameters)
+
+		json.Unmarshal(data, &parameters)
+
+		util.RedactCredentials(parameters)
+
+		m.Parameters = parameters
+
+	}
+
+	return m
+
+}
+
+/*
+validate checks that the monitor names its target and carries the credentials its SystemType needs.
+*/
+func (m Monitor) validate() error {
+
+	if m.SystemType == "" {
+
+		return errors.New("missing required field: SystemType")
+
+	}
+
+	if url, _ := m.Parameters["url"].(string); m.IP == "" && url == "" {
+
+		return errors.New("missing required field: ip, or url in parameters")
+
+	}
+
+	credentials := map[string]string{"username": m.Username, "password": m.Password}
+
+	for _, field := range credentialFields[m.SystemType] {
+
+		if credentials[field] == "" {
+
+			return fmt.Errorf("missing required field for %s monitors: %s", m.SystemType, field)
+
+		}
+
+	}
+
+	if m.Interval < MinInterval {
+
+		return fmt.Errorf("interval must be at least %d second(s)", MinInterval)
+
+	}
+
+	if m.Jitter < 0 {
+
+		return errors.New("jitter must not be negative")
+
+	}
+
+	return nil
+
+}
+
+type entry struct {
+	monitor Monitor
+	state   MonitorState
+	stop    chan struct{}
+}
+
+/*
+Scheduler runs the registered monitors at their configured cadence by handing their requests to submit.
+Monitors and their last-run state are persisted in store, when one could be opened.
+*/
+type Scheduler struct {
+	mutex    sync.Mutex
+	monitors map[string]*entry
+	submit   func(string) bool
+	store    *Store
+}
+
+var (
+	instanceMutex sync.Mutex
+	instance      *Scheduler
+)
+
+/*
+Start creates the engine scheduler and resumes the monitors persisted in the store at storePath.
+submit hands a request to the worker pool and reports whether it was accepted.
+If the store cannot be opened the scheduler runs without persistence.
+*/
+func Start(submit func(string) bool, storePath string) *Scheduler {
+
+	instanceMutex.Lock()
+
+	defer instanceMutex.Unlock()
+
+	instance = &Scheduler{monitors: make(map[string]*entry), submit: submit}
+
+	store, err := OpenStore(storePath)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("failed to open monitor store %s, schedules will not be persisted: %v", storePath, err))
+
+	} else {
+
+		instance.store = store
+
+		instance.mutex.Lock()
+
+		for _, persisted := range store.Records() {
+
+			scheduled := instance.launch(persisted.Monitor)
+
+			scheduled.state = persisted.State
+
+			instance.monitors[persisted.Monitor.ID] = scheduled
+
+		}
+
+		instance.mutex.Unlock()
+
+		logInstance.LogInfo(fmt.Sprintf("Restored %d monitors from %s", len(instance.monitors), storePath))
+
+	}
+
+	logInstance.LogInfo("Scheduler started")
+
+	return instance
+
+}
+
+/*
+Instance returns the scheduler created by Start, or nil if it has not been started.
+*/
+func Instance() *Scheduler {
+
+	instanceMutex.Lock()
+
+	defer instanceMutex.Unlock()
+
+	return instance
+
+}
+
+/*
+Add registers a new monitor and starts running it unless it is paused.
+*/
+func (s *Scheduler) Add(monitor Monitor) error {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	if _, exists := s.monitors[monitor.ID]; exists {
+
+		return ErrMonitorExists
+
+	}
+
+	s.monitors[monitor.ID] = s.launch(monitor)
+
+	s.persist(monitor, MonitorState{})
+
+	logInstance.LogInfo(fmt.Sprintf("Scheduled %s monitor %s every %ds", monitor.SystemType, monitor.ID, monitor.Interval))
+
+	return nil
+
+}
+
+/*
+Update replaces a registered monitor and restarts its schedule.
+*/
+func (s *Scheduler) Update(monitor Monitor) error {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	current, exists := s.monitors[monitor.ID]
+
+	if !exists {
+
+		return ErrMonitorNotFound
+
+	}
+
+	close(current.stop)
+
+	updated := s.launch(monitor)
+
+	updated.state = current.state
+
+	s.monitors[monitor.ID] = updated
+
+	s.persist(monitor, updated.state)
+
+	logInstance.LogInfo("Updated monitor " + monitor.ID)
+
+	return nil
+
+}
+
+/*
+Remove stops and unregisters a monitor.
+*/
+func (s *Scheduler) Remove(id string) error {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	current, exists := s.monitors[id]
+
+	if !exists {
+
+		return ErrMonitorNotFound
+
+	}
+
+	close(current.stop)
+
+	delete(s.monitors, id)
+
+	if s.store != nil {
+
+		if err := s.store.Delete(id); err != nil {
+
+			logInstance.LogError(fmt.Errorf("failed to delete monitor %s from store: %v", id, err))
+
+		}
+
+	}
+
+	logInstance.LogInfo("Deleted monitor " + id)
+
+	return nil
+
+}
+
+/*
+Get returns a registered monitor.
+*/
+func (s *Scheduler) Get(id string) (Monitor, bool) {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	current, exists := s.monitors[id]
+
+	if !exists {
+
+		return Monitor{}, false
+
+	}
+
+	return current.monitor, true
+
+}
+
+/*
+List returns the registered monitors and their last-run state, credentials redacted.
+*/
+func (s *Scheduler) List() []Record {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	records := make([]Record, 0, len(s.monitors))
+
+	for _, current := range s.monitors {
+
+		records = append(records, Record{Monitor: current.monitor.redacted(), State: current.state})
+
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Monitor.ID < records[j].Monitor.ID })
+
+	return records
+
+}
+
+/*
+RecordResult stores the status of the response to a scheduled request of a monitor.
+*/
+func (s *Scheduler) RecordResult(id string, status string) {
+
+	s.updateState(id, func(state *MonitorState) {
+
+		state.LastResult = time.Now()
+
+		state.LastStatus = status
+
+	})
+
+}
+
+/*
+updateState applies update to the state of a monitor and persists it.
+*/
+func (s *Scheduler) updateState(id string, update func(*MonitorState)) {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	current, exists := s.monitors[id]
+
+	if !exists {
+
+		return
+
+	}
+
+	update(&current.state)
+
+	if s.store != nil {
+
+		if err := s.store.PutState(id, current.state); err != nil {
+
+			logInstance.LogError(fmt.Errorf("failed to persist state of monitor %s: %v", id, err))
+
+		}
+
+	}
+
+}
+
+/*
+persist stores a monitor and its state. It must be called with the mutex held.
+*/
+func (s *Scheduler) persist(monitor Monitor, state MonitorState) {
+
+	if s.store == nil {
+
+		return
+
+	}
+
+	if err := s.store.Put(monitor, state); err != nil {
+
+		logInstance.LogError(fmt.Errorf("failed to persist monitor %s: %v", monitor.ID, err))
+
+	}
+
+}
+
+/*
+launch starts the run loop of a monitor. It must be called with the mutex held.
+*/
+func (s *Scheduler) launch(monitor Monitor) *entry {
+
+	scheduled := &entry{monitor: monitor, stop: make(chan struct{})}
+
+	if !monitor.Paused {
+
+		go s.run(monitor, scheduled.stop)
+
+	}
+
+	return scheduled
+
+}
+
+/*
+run submits the request of a monitor every interval plus jitter until stop is closed.
+A run is skipped when the worker pool does not accept it.
+*/
+func (s *Scheduler) run(monitor Monitor, stop chan struct{}) {
+
+	request, err := monitor.request()
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("failed to build request for monitor %s: %v", monitor.ID, err))
+
+		return
+
+	}
+
+	timer := time.NewTimer(nextDelay(monitor))
+
+	defer timer.Stop()
+
+	for {
+
+		select {
+
+		case <-stop:
+
+			return
+
+		case <-timer.C:
+
+			accepted := s.submit(request)
+
+			if !accepted {
+
+				logInstance.LogWarning("Worker pool busy, skipped run of monitor " + monitor.ID)
+
+			}
+
+			s.updateState(monitor.ID, func(state *MonitorState) {
+
+				state.LastRun = time.Now()
+
+				if accepted {
+
+					state.RunCount++
+
+				} else {
+
+					state.SkippedCount++
+
+				}
+
+			})
+
+			timer.Reset(nextDelay(monitor))
+
+		}
+
+	}
+
+}
+
+func nextDelay(monitor Monitor) time.Duration {
+
+	delay := time.Duration(monitor.Interval) * time.Second
+
+	if monitor.Jitter > 0 {
+
+		delay += time.Duration(rand.Int63n(int64(monitor.Jitter) * int64(time.Second)))
+
+	}
+
+	return delay
+
+}
@@ -0,0 +1,407 @@
+package scheduler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultStorePath = "data/monitors.json"
+	compactThreshold = 1000
+	journalOpPut     = "put"
+	journalOpState   = "state"
+	journalOpDelete  = "delete"
+)
+
+/*
+MonitorState is the last-run state of a monitor.
+*/
+type MonitorState struct {
+	LastRun      time.Time `json:"lastRun,omitempty"`
+	LastResult   time.Time `json:"lastResult,omitempty"`
+	LastStatus   string    `json:"lastStatus,omitempty"`
+	RunCount     int64     `json:"runCount"`
+	SkippedCount int64     `json:"skippedCount"`
+}
+
+/*
+Record is a monitor and its state as persisted in the store.
+*/
+type Record struct {
+	Monitor Monitor      `json:"monitor"`
+	State   MonitorState `json:"state"`
+}
+
+/*
+journalEntry is a single line of the store journal.
+A put entry stores a whole record, a state entry updates the state of a record and a delete entry removes it.
+*/
+type journalEntry struct {
+	Op      string        `json:"op"`
+	ID      string        `json:"monitorId,omitempty"`
+	Monitor *Monitor      `json:"monitor,omitempty"`
+	State   *MonitorState `json:"state,omitempty"`
+}
+
+/*
+Store persists the registered monitors and their last-run state in an append-only JSON journal.
+The journal is replayed on open and compacted into one put entry per monitor when it grows too long.
+It holds the credentials of the monitors in clear text, so it is only readable by the user of the engine
+(mode 0600, in a directory created with mode 0700).
+*/
+type Store struct {
+	mutex   sync.Mutex
+	path    string
+	file    *os.File
+	records map[string]Record
+	entries int
+}
+
+/*
+OpenStore replays the journal at path, compacts it and opens it for appending.
+A missing journal is created.
+*/
+func OpenStore(path string) (*Store, error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+
+		return nil, err
+
+	}
+
+	store := &Store{path: path, records: make(map[string]Record)}
+
+	if err := store.replay(); err != nil {
+
+		return nil, err
+
+	}
+
+	if err := store.compact(); err != nil {
+
+		return nil, err
+
+	}
+
+	return store, nil
+
+}
+
+/*
+Records returns the persisted monitors and their state.
+*/
+func (s *Store) Records() []Record {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	records := make([]Record, 0, len(s.records))
+
+	for _, current := range s.records {
+
+		records = append(records, current)
+
+	}
+
+	return records
+
+}
+
+/*
+Put stores a monitor and its state.
+*/
+func (s *Store) Put(monitor Monitor, state MonitorState) error {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	s.records[monitor.ID] = Record{Monitor: monitor, State: state}
+
+	return s.append(journalEntry{Op: journalOpPut, Monitor: &monitor, State: &state})
+
+}
+
+/*
+PutState stores the state of a monitor already in the store.
+*/
+func (s *Store) PutState(id string, state MonitorState) error {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	current, exists := s.records[id]
+
+	if !exists {
+
+		return ErrMonitorNotFound
+
+	}
+
+	current.State = state
+
+	s.records[id] = current
+
+	return s.append(journalEntry{Op: journalOpState, ID: id, State: &state})
+
+}
+
+/*
+Delete removes a monitor from the store.
+*/
+func (s *Store) Delete(id string) error {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	delete(s.records, id)
+
+	return s.append(journalEntry{Op: journalOpDelete, ID: id})
+
+}
+
+/*
+Close closes the journal file.
+*/
+func (s *Store) Close() error {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+
+}
+
+/*
+replay applies the entries of the journal to the in-memory records.
+A truncated last line, e.g. after a crash during a write, is ignored.
+*/
+func (s *Store) replay() error {
+
+	file, err := os.Open(s.path)
+
+	if os.IsNotExist(err) {
+
+		return nil
+
+	}
+
+	if err != nil {
+
+		return err
+
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+
+		var entry journalEntry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+
+			logInstance.LogWarning(fmt.Sprintf("Skipping invalid journal entry in %s: %v", s.path, err))
+
+			continue
+
+		}
+
+		switch entry.Op {
+
+		case journalOpPut:
+
+			if entry.Monitor != nil && entry.State != nil {
+
+				s.records[entry.Monitor.ID] = Record{Monitor: *entry.Monitor, State: *entry.State}
+
+			}
+
+		case journalOpState:
+
+			if current, exists := s.records[entry.ID]; exists && entry.State != nil {
+
+				current.State = *entry.State
+
+				s.records[entry.ID] = current
+
+			}
+
+		case journalOpDelete:
+
+			delete(s.records, entry.ID)
+
+		}
+
+	}
+
+	return scanner.Err()
+
+}
+
+/*
+compact rewrites the journal with one put entry per record and reopens it for appending.
+The new journal is written to a temporary file and renamed over the old one; if that fails, the old journal is
+kept and reopened, so the store can go on appending to it.
+*/
+func (s *Store) compact() error {
+
+	temporaryPath := s.path + ".tmp"
+
+	if err := s.writeJournal(temporaryPath); err != nil {
+
+		os.Remove(temporaryPath)
+
+		return err
+
+	}
+
+	if s.file != nil {
+
+		s.file.Close()
+
+	}
+
+	if err := os.Rename(temporaryPath, s.path); err != nil {
+
+		os.Remove(temporaryPath)
+
+		var reopenErr error
+
+		if s.file, reopenErr = os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); reopenErr != nil {
+
+			s.file = nil
+
+			return fmt.Errorf("%v, and reopening the journal failed: %v", err, reopenErr)
+
+		}
+
+		return err
+
+	}
+
+	var err error
+
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+
+	if err != nil {
+
+		s.file = nil
+
+		return err
+
+	}
+
+	s.entries = len(s.records)
+
+	return nil
+
+}
+
+/*
+writeJournal writes one put entry per record to a new file at path, readable by the user of the engine only.
+*/
+func (s *Store) writeJournal(path string) error {
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	defer file.Close()
+
+	// the mode of OpenFile only applies to new files, and a stale temporary file may have another
+	if err := file.Chmod(0600); err != nil {
+
+		return err
+
+	}
+
+	writer := bufio.NewWriter(file)
+
+	encoder := json.NewEncoder(writer)
+
+	for _, current := range s.records {
+
+		monitor, state := current.Monitor, current.State
+
+		if err := encoder.Encode(journalEntry{Op: journalOpPut, Monitor: &monitor, State: &state}); err != nil {
+
+			return err
+
+		}
+
+	}
+
+	if err := writer.Flush(); err != nil {
+
+		return err
+
+	}
+
+	if err := file.Sync(); err != nil {
+
+		return err
+
+	}
+
+	return file.Close()
+
+}
+
+/*
+append writes an entry to the journal, compacting it first when it has grown past the threshold.
+A failed compaction is retried with the next entry. It must be called with the mutex held.
+*/
+func (s *Store) append(entry journalEntry) error {
+
+	if s.entries > compactThreshold+2*len(s.records) {
+
+		if err := s.compact(); err != nil {
+
+			logInstance.LogWarning(fmt.Sprintf("Failed to compact journal %s: %v", s.path, err))
+
+		}
+
+	}
+
+	if s.file == nil {
+
+		return fmt.Errorf("journal %s is not open", s.path)
+
+	}
+
+	data, err := json.Marshal(entry)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+
+		return err
+
+	}
+
+	s.entries++
+
+	return nil
+
+}
@@ -0,0 +1,605 @@
+package server
+
+import (
+	"NMS/src/util"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	grpcServiceName = "nms.v1.Engine"
+
+	// DefaultSubscriptionBuffer is the number of messages a slow subscriber may fall behind before messages are dropped.
+	DefaultSubscriptionBuffer = 100
+)
+
+/*
+EngineServer is the gRPC API of the engine, described in engine.proto. Requests and responses are the JSON
+requests and responses of the ZeroMQ API carried as google.protobuf.Struct.
+*/
+type EngineServer interface {
+	Discover(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	Poll(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	Health(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	Subscribe(*structpb.Struct, grpc.ServerStream) error
+}
+
+/*
+GRPCStats reports the activity of the gRPC API in the health response.
+Dropped counts the messages that subscribers missed because they did not keep up.
+*/
+type GRPCStats struct {
+	Address     string `json:"address"`
+	Requests    uint64 `json:"requests"`
+	Subscribers int    `json:"subscribers"`
+	Dropped     uint64 `json:"dropped"`
+}
+
+/*
+engineService implements EngineServer on top of the worker pool, so that gRPC requests go through the same lanes,
+plugins, validation, limits and circuit breakers as the ZeroMQ requests.
+*/
+type engineService struct {
+	address  string
+	mutex    sync.Mutex
+	requests uint64
+}
+
+var engineServiceDesc = grpc.ServiceDesc{
+
+	ServiceName: grpcServiceName,
+
+	HandlerType: (*EngineServer)(nil),
+
+	Methods: []grpc.MethodDesc{
+
+		{MethodName: "Discover", Handler: unaryHandler("Discover", EngineServer.Discover)},
+
+		{MethodName: "Poll", Handler: unaryHandler("Poll", EngineServer.Poll)},
+
+		{MethodName: "Health", Handler: unaryHandler("Health", EngineServer.Health)},
+	},
+
+	Streams: []grpc.StreamDesc{
+
+		{StreamName: "Subscribe", Handler: subscribeHandler, ServerStreams: true},
+	},
+
+	Metadata: "src/server/engine.proto",
+}
+
+/*
+serveGRPC serves the gRPC API on the address of config until the listener fails.
+The API is not started when its configuration would leave it unauthenticated or, with CURVE enabled, unencrypted.
+*/
+func serveGRPC(service *engineService, config util.GRPCConfig) {
+
+	options, err := grpcServerOptions(config, curve != nil)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("refusing to start gRPC API: %v", err))
+
+		return
+
+	}
+
+	listener, err := net.Listen("tcp", config.Address)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("failed to listen for gRPC on %s: %v", config.Address, err))
+
+		return
+
+	}
+
+	server := grpc.NewServer(options...)
+
+	server.RegisterService(&engineServiceDesc, service)
+
+	logInstance.LogInfo("gRPC API is ready on " + config.Address)
+
+	if err := server.Serve(listener); err != nil {
+
+		logInstance.LogError(fmt.Errorf("gRPC server stopped: %v", err))
+
+	}
+
+}
+
+/*
+grpcServerOptions returns the TLS credentials and the authentication interceptors of the gRPC server.
+
+Returns:
+  - The server options.
+  - An error if the certificates cannot be loaded, if no client authentication is configured, or if the API would
+    be served in cleartext while curveEnabled.
+*/
+func grpcServerOptions(config util.GRPCConfig, curveEnabled bool) ([]grpc.ServerOption, error) {
+
+	var options []grpc.ServerOption
+
+	if config.ClientCAFile == "" && len(config.Tokens) == 0 {
+
+		return nil, errors.New("no client authentication configured, set clientCAFile or tokens")
+
+	}
+
+	if config.CertFile == "" || config.KeyFile == "" {
+
+		if curveEnabled {
+
+			return nil, errors.New("CURVE security is enabled but the gRPC API has no TLS certificate")
+
+		}
+
+		if config.ClientCAFile != "" {
+
+			return nil, errors.New("clientCAFile requires certFile and keyFile")
+
+		}
+
+		logInstance.LogWarning("gRPC API is served without TLS, tokens and credentials in requests travel in cleartext")
+
+	} else {
+
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+
+		if err != nil {
+
+			return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+
+		}
+
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+
+		if config.ClientCAFile != "" {
+
+			data, err := os.ReadFile(config.ClientCAFile)
+
+			if err != nil {
+
+				return nil, fmt.Errorf("failed to read clientCAFile: %v", err)
+
+			}
+
+			clientCAs := x509.NewCertPool()
+
+			if !clientCAs.AppendCertsFromPEM(data) {
+
+				return nil, fmt.Errorf("no certificate found in clientCAFile %s", config.ClientCAFile)
+
+			}
+
+			tlsConfig.ClientCAs = clientCAs
+
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		}
+
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+
+	}
+
+	if len(config.Tokens) > 0 {
+
+		authenticator := tokenAuthenticator(config.Tokens)
+
+		options = append(options,
+
+			grpc.UnaryInterceptor(func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+				if err := authenticator(ctx); err != nil {
+
+					return nil, err
+
+				}
+
+				return handler(ctx, request)
+
+			}),
+
+			grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+				if err := authenticator(stream.Context()); err != nil {
+
+					return err
+
+				}
+
+				return handler(srv, stream)
+
+			}),
+		)
+
+	}
+
+	return options, nil
+
+}
+
+/*
+tokenAuthenticator returns a check that the metadata of a call carries one of tokens as a bearer token.
+Clients authenticated by certificate only still need a token when tokens are configured.
+*/
+func tokenAuthenticator(tokens []string) func(context.Context) error {
+
+	return func(ctx context.Context) error {
+
+		incoming, _ := metadata.FromIncomingContext(ctx)
+
+		for _, value := range incoming.Get("authorization") {
+
+			presented, found := strings.CutPrefix(value, "Bearer ")
+
+			if !found {
+
+				continue
+
+			}
+
+			for _, token := range tokens {
+
+				if token != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+
+					return nil
+
+				}
+
+			}
+
+		}
+
+		return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+
+	}
+
+}
+
+/*
+Discover runs a discovery request.
+*/
+func (s *engineService) Discover(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+
+	return s.call(ctx, RequestTypeDiscovery, in)
+
+}
+
+/*
+Poll runs a provisioning request, which collects the metrics of a target.
+*/
+func (s *engineService) Poll(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+
+	return s.call(ctx, RequestTypeProvisioning, in)
+
+}
+
+/*
+Health runs a health request.
+*/
+func (s *engineService) Health(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+
+	return s.call(ctx, RequestTypeHealth, in)
+
+}
+
+/*
+call hands a request of requestType to the worker pool and waits for its response until ctx ends.
+A request that fails in a plugin is a successful call with status fail in the response, as on the ZeroMQ API.
+*/
+func (s *engineService) call(ctx context.Context, requestType string, in *structpb.Struct) (*structpb.Struct, error) {
+
+	s.mutex.Lock()
+
+	s.requests++
+
+	s.mutex.Unlock()
+
+	requestData := in.AsMap()
+
+	requestData["RequestType"] = requestType
+
+	request, err := json.Marshal(requestData)
+
+	if err != nil {
+
+		return nil, status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+
+	}
+
+	responses := make(chan string, 1)
+
+	if err := pool.submitContext(ctx, string(request), func(response string) { responses <- response }); err != nil {
+
+		return nil, status.FromContextError(err).Err()
+
+	}
+
+	select {
+
+	case response := <-responses:
+
+		var responseData map[string]interface{}
+
+		if err := json.Unmarshal([]byte(response), &responseData); err != nil {
+
+			return nil, status.Errorf(codes.Internal, "invalid response: %v", err)
+
+		}
+
+		out, err := structpb.NewStruct(responseData)
+
+		if err != nil {
+
+			return nil, status.Errorf(codes.Internal, "invalid response: %v", err)
+
+		}
+
+		return out, nil
+
+	case <-ctx.Done():
+
+		return nil, status.FromContextError(ctx.Err()).Err()
+
+	}
+
+}
+
+/*
+Subscribe streams the responses and events published by the engine until the client cancels.
+The request may list topics, the prefixes of the topics to receive (see publisher), e.g. ["metrics.windows.",
+"alerts.*"] or ["metrics.windows.10.0.0.1."] for one host; a trailing * is ignored and no topics means all. Every message is a Struct with the topic and the
+message.
+*/
+func (s *engineService) Subscribe(in *structpb.Struct, stream grpc.ServerStream) error {
+
+	var prefixes []string
+
+	for _, value := range in.GetFields()["topics"].GetListValue().GetValues() {
+
+		prefixes = append(prefixes, strings.TrimSuffix(value.GetStringValue(), "*"))
+
+	}
+
+	subscription := subscribers.add(prefixes)
+
+	defer subscribers.remove(subscription)
+
+	for {
+
+		select {
+
+		case <-stream.Context().Done():
+
+			return nil
+
+		case frames := <-subscription.messages:
+
+			var message interface{}
+
+			if err := json.Unmarshal([]byte(frames[1]), &message); err != nil {
+
+				message = frames[1]
+
+			}
+
+			// responses are redacted before they are published; this also covers any other message
+			if fields, ok := message.(map[string]interface{}); ok {
+
+				util.RedactCredentials(fields)
+
+			}
+
+			out, err := structpb.NewStruct(map[string]interface{}{"topic": frames[0], "message": message})
+
+			if err != nil {
+
+				logInstance.LogError(fmt.Errorf("failed to convert message on %s for gRPC subscriber: %v", frames[0], err))
+
+				continue
+
+			}
+
+			if err := stream.SendMsg(out); err != nil {
+
+				return err
+
+			}
+
+		}
+
+	}
+
+}
+
+/*
+Stats returns a snapshot of the gRPC API statistics.
+*/
+func (s *engineService) Stats() GRPCStats {
+
+	s.mutex.Lock()
+
+	stats := GRPCStats{Address: s.address, Requests: s.requests}
+
+	s.mutex.Unlock()
+
+	stats.Subscribers, stats.Dropped = subscribers.stats()
+
+	return stats
+
+}
+
+func unaryHandler(method string, call func(EngineServer, context.Context, *structpb.Struct) (*structpb.Struct, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+
+	return func(srv interface{}, ctx context.Context, decode func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+		in := new(structpb.Struct)
+
+		if err := decode(in); err != nil {
+
+			return nil, err
+
+		}
+
+		if interceptor == nil {
+
+			return call(srv.(EngineServer), ctx, in)
+
+		}
+
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcServiceName + "/" + method}
+
+		return interceptor(ctx, in, info, func(ctx context.Context, request interface{}) (interface{}, error) {
+
+			return call(srv.(EngineServer), ctx, request.(*structpb.Struct))
+
+		})
+
+	}
+
+}
+
+func subscribeHandler(srv interface{}, stream grpc.ServerStream) error {
+
+	in := new(structpb.Struct)
+
+	if err := stream.RecvMsg(in); err != nil {
+
+		return err
+
+	}
+
+	return srv.(EngineServer).Subscribe(in, stream)
+
+}
+
+/*
+subscription is a gRPC subscriber and the topic prefixes it receives.
+*/
+type subscription struct {
+	prefixes []string
+	messages chan [2]string
+}
+
+/*
+subscriptionSet delivers the published messages to the gRPC subscribers, dropping the messages of a subscriber
+whose buffer is full rather than slowing down the workers.
+*/
+type subscriptionSet struct {
+	mutex   sync.Mutex
+	members map[*subscription]struct{}
+	dropped uint64
+}
+
+var subscribers = &subscriptionSet{members: make(map[*subscription]struct{})}
+
+func (s *subscriptionSet) add(prefixes []string) *subscription {
+
+	member := &subscription{prefixes: prefixes, messages: make(chan [2]string, DefaultSubscriptionBuffer)}
+
+	s.mutex.Lock()
+
+	s.members[member] = struct{}{}
+
+	s.mutex.Unlock()
+
+	return member
+
+}
+
+func (s *subscriptionSet) remove(member *subscription) {
+
+	s.mutex.Lock()
+
+	delete(s.members, member)
+
+	s.mutex.Unlock()
+
+}
+
+func (s *subscriptionSet) active() bool {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	return len(s.members) > 0
+
+}
+
+func (s *subscriptionSet) deliver(topic string, message string) {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	for member := range s.members {
+
+		if !member.wants(topic) {
+
+			continue
+
+		}
+
+		select {
+
+		case member.messages <- [2]string{topic, message}:
+
+		default:
+
+			s.dropped++
+
+		}
+
+	}
+
+}
+
+func (s *subscriptionSet) stats() (int, uint64) {
+
+	s.mutex.Lock()
+
+	defer s.mutex.Unlock()
+
+	return len(s.members), s.dropped
+
+}
+
+func (s *subscription) wants(topic string) bool {
+
+	if len(s.prefixes) == 0 {
+
+		return true
+
+	}
+
+	for _, prefix := range s.prefixes {
+
+		if strings.HasPrefix(topic, prefix) {
+
+			return true
+
+		}
+
+	}
+
+	return false
+
+}
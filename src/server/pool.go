@@ -0,0 +1,716 @@
+package server
+
+import (
+	"NMS/src/util"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMinWorkers  = 5
+	DefaultMaxWorkers  = 50
+	DefaultIdleTimeout = 30 * time.Second
+	DefaultTargetWait  = time.Second
+	scaleInterval      = 250 * time.Millisecond
+	latencySmoothing   = 0.2
+)
+
+/*
+Priority lanes, from the highest to the lowest priority. A free worker always takes the oldest request of the
+highest non-empty lane it is admitted to.
+*/
+const (
+	LaneControl = iota
+	LaneDiscovery
+	LanePolling
+	LaneBulk
+	laneCount
+)
+
+var (
+	laneNames = [laneCount]string{"control", "discovery", "polling", "bulk"}
+
+	// requestLanes maps a request type to its lane. Unknown and malformed requests are answered at once
+	// with an error, so they go to the control lane.
+	requestLanes = map[string]int{
+		RequestTypeHealth:       LaneControl,
+		RequestTypeStatus:       LaneControl,
+		RequestTypeSchedule:     LaneControl,
+		RequestTypeListMonitors: LaneControl,
+		RequestTypeDiscovery:    LaneDiscovery,
+		RequestTypeProvisioning: LanePolling,
+		RequestTypeInventory:    LaneBulk,
+		RequestTypeExecute:      LaneBulk,
+	}
+
+	// defaultReservedWorkers keeps a worker free for control requests and one for discovery requests.
+	defaultReservedWorkers = [laneCount]int{1, 1, 0, 0}
+)
+
+/*
+LaneStats reports the activity of a priority lane.
+*/
+type LaneStats struct {
+	Queued    int    `json:"queued"`
+	Processed uint64 `json:"processed"`
+	Reserved  int    `json:"reserved"`
+}
+
+/*
+PoolStats reports the state of the worker pool in the health response.
+*/
+type PoolStats struct {
+	Workers          int                  `json:"workers"`
+	Busy             int                  `json:"busy"`
+	MinWorkers       int                  `json:"minWorkers"`
+	MaxWorkers       int                  `json:"maxWorkers"`
+	Queued           int                  `json:"queued"`
+	Processed        uint64               `json:"processed"`
+	AverageLatencyMs float64              `json:"averageLatencyMs"`
+	Started          uint64               `json:"started"`
+	Retired          uint64               `json:"retired"`
+	Lanes            map[string]LaneStats `json:"lanes"`
+}
+
+/*
+task is a request in the pool. reply delivers the response to the client that sent the request; it is nil for
+the requests of the PULL socket and of the scheduler, whose responses go to the result queue.
+*/
+type task struct {
+	lane    int
+	request string
+	reply   func(string)
+}
+
+/*
+workerPool runs the requests handed over by the receiver and the scheduler on a number of workers that grows
+with the backlog and shrinks when workers stay idle.
+
+Requests are sorted into priority lanes by a single dispatcher, which owns the lane queues and the busy count.
+A worker only takes a request of a lane when enough workers stay free for the reservations of the lanes above it,
+so heavy polling or bulk traffic never occupies the capacity kept for control and discovery requests.
+*/
+type workerPool struct {
+	inbound     chan task
+	scheduled   chan string
+	work        chan task
+	done        chan int
+	wake        chan struct{}
+	process     func(string, func(string))
+	queueSize   int
+	minWorkers  int
+	maxWorkers  int
+	idleTimeout time.Duration
+	targetWait  time.Duration
+	reserved    [laneCount]int
+	queues      [laneCount][]task
+	mutex       sync.Mutex
+	workers     int
+	busy        int
+	nextID      int
+	queued      [laneCount]int
+	processed   [laneCount]uint64
+	started     uint64
+	retired     uint64
+	latency     time.Duration
+}
+
+/*
+newWorkerPool returns a pool that reads inbound requests from a queue of queueSize and scheduled requests from
+scheduled, and runs them with process. Every lane holds up to queueSize requests.
+Missing or inconsistent settings fall back to the defaults.
+*/
+func newWorkerPool(config util.WorkerPoolConfig, queueSize int, scheduled chan string, process func(string, func(string))) *workerPool {
+
+	if queueSize <= 0 {
+
+		queueSize = DefaultQueueSize
+
+	}
+
+	pool := &workerPool{
+
+		inbound: make(chan task, queueSize),
+
+		scheduled: scheduled,
+
+		work: make(chan task),
+
+		wake: make(chan struct{}, 1),
+
+		process: process,
+
+		queueSize: queueSize,
+
+		minWorkers: config.MinWorkers,
+
+		maxWorkers: config.MaxWorkers,
+
+		idleTimeout: time.Duration(config.IdleTimeoutSeconds) * time.Second,
+
+		targetWait: time.Duration(config.TargetWaitMs) * time.Millisecond,
+
+		reserved: defaultReservedWorkers,
+	}
+
+	if pool.minWorkers <= 0 {
+
+		pool.minWorkers = DefaultMinWorkers
+
+	}
+
+	if pool.maxWorkers <= 0 {
+
+		pool.maxWorkers = DefaultMaxWorkers
+
+	}
+
+	if pool.maxWorkers < pool.minWorkers {
+
+		pool.maxWorkers = pool.minWorkers
+
+	}
+
+	if pool.idleTimeout <= 0 {
+
+		pool.idleTimeout = DefaultIdleTimeout
+
+	}
+
+	if pool.targetWait <= 0 {
+
+		pool.targetWait = DefaultTargetWait
+
+	}
+
+	if config.ReservedWorkers != nil {
+
+		for lane, name := range laneNames {
+
+			pool.reserved[lane] = config.ReservedWorkers[name]
+
+		}
+
+	}
+
+	if total := pool.reservedAbove(laneCount); total >= pool.minWorkers {
+
+		logInstance.LogWarning(fmt.Sprintf("Reserved workers (%d) leave no worker for the lowest lane with %d workers, ignoring reservations", total, pool.minWorkers))
+
+		pool.reserved = [laneCount]int{}
+
+	}
+
+	pool.done = make(chan int, pool.maxWorkers)
+
+	return pool
+
+}
+
+/*
+start launches the dispatcher, the minimum number of workers and the scaler.
+*/
+func (p *workerPool) start() {
+
+	p.mutex.Lock()
+
+	p.grow(p.minWorkers)
+
+	p.mutex.Unlock()
+
+	go p.dispatch()
+
+	go p.scale()
+
+}
+
+/*
+submit queues an inbound request, blocking while the queue is full so that the receiver stops reading
+and the socket high-water mark takes over. reply is nil for requests whose response goes to the result queue.
+*/
+func (p *workerPool) submit(request string, reply func(string)) {
+
+	p.inbound <- task{request: request, reply: reply}
+
+}
+
+/*
+trySubmit queues an inbound request like submit, but returns false instead of blocking when the queue is full.
+*/
+func (p *workerPool) trySubmit(request string, reply func(string)) bool {
+
+	select {
+
+	case p.inbound <- task{request: request, reply: reply}:
+
+		return true
+
+	default:
+
+		return false
+
+	}
+
+}
+
+/*
+submitContext queues an inbound request like submit, but gives up when ctx ends first.
+*/
+func (p *workerPool) submitContext(ctx context.Context, request string, reply func(string)) error {
+
+	select {
+
+	case p.inbound <- task{request: request, reply: reply}:
+
+		return nil
+
+	case <-ctx.Done():
+
+		return ctx.Err()
+
+	}
+
+}
+
+/*
+laneOf returns the lane of a request from its RequestType.
+*/
+func laneOf(request string) int {
+
+	var header struct {
+		RequestType string `json:"RequestType"`
+	}
+
+	if err := json.Unmarshal([]byte(request), &header); err != nil {
+
+		return LaneControl
+
+	}
+
+	if lane, ok := requestLanes[header.RequestType]; ok {
+
+		return lane
+
+	}
+
+	return LaneControl
+
+}
+
+/*
+reservedAbove returns the number of workers reserved for the lanes of higher priority than lane.
+*/
+func (p *workerPool) reservedAbove(lane int) int {
+
+	total := 0
+
+	for higher := 0; higher < lane; higher++ {
+
+		total += p.reserved[higher]
+
+	}
+
+	return total
+
+}
+
+/*
+dispatch sorts the inbound and scheduled requests into their lanes and hands them to free workers by priority.
+When the lane of an inbound request is full, the request is held and no further request is read until the lane
+has room again.
+*/
+func (p *workerPool) dispatch() {
+
+	var held *task
+
+	accept := func(incoming task) {
+
+		incoming.lane = laneOf(incoming.request)
+
+		if len(p.queues[incoming.lane]) >= p.queueSize {
+
+			held = &incoming
+
+		} else {
+
+			p.enqueue(incoming)
+
+		}
+
+	}
+
+	for {
+
+		if held != nil && len(p.queues[held.lane]) < p.queueSize {
+
+			p.enqueue(*held)
+
+			held = nil
+
+		}
+
+		// sort everything already waiting into the lanes first, so that priorities apply to the whole backlog
+	drain:
+		for held == nil {
+
+			select {
+
+			case incoming := <-p.inbound:
+
+				accept(incoming)
+
+			default:
+
+				break drain
+
+			}
+
+		}
+
+	drainScheduled:
+		for len(p.queues[LanePolling]) < p.queueSize {
+
+			select {
+
+			case request := <-p.scheduled:
+
+				p.enqueue(task{lane: LanePolling, request: request})
+
+			default:
+
+				break drainScheduled
+
+			}
+
+		}
+
+		p.mutex.Lock()
+
+		next := -1
+
+		for lane := 0; lane < laneCount; lane++ {
+
+			if len(p.queues[lane]) > 0 && p.workers-p.busy-1 >= p.reservedAbove(lane) {
+
+				next = lane
+
+				break
+
+			}
+
+		}
+
+		p.mutex.Unlock()
+
+		inbound, scheduled := p.inbound, p.scheduled
+
+		if held != nil {
+
+			inbound = nil
+
+		}
+
+		if len(p.queues[LanePolling]) >= p.queueSize {
+
+			scheduled = nil
+
+		}
+
+		var work chan task
+
+		var candidate task
+
+		if next >= 0 {
+
+			work = p.work
+
+			candidate = p.queues[next][0]
+
+		}
+
+		select {
+
+		case incoming := <-inbound:
+
+			accept(incoming)
+
+		case request := <-scheduled:
+
+			p.enqueue(task{lane: LanePolling, request: request})
+
+		case work <- candidate:
+
+			p.queues[next] = p.queues[next][1:]
+
+			p.mutex.Lock()
+
+			p.queued[next]--
+
+			p.busy++
+
+			p.mutex.Unlock()
+
+		case lane := <-p.done:
+
+			p.mutex.Lock()
+
+			p.busy--
+
+			p.processed[lane]++
+
+			p.mutex.Unlock()
+
+		case <-p.wake:
+
+		}
+
+	}
+
+}
+
+func (p *workerPool) enqueue(item task) {
+
+	p.queues[item.lane] = append(p.queues[item.lane], item)
+
+	p.mutex.Lock()
+
+	p.queued[item.lane]++
+
+	p.mutex.Unlock()
+
+}
+
+/*
+grow starts count workers. The caller must hold the mutex.
+*/
+func (p *workerPool) grow(count int) {
+
+	for i := 0; i < count; i++ {
+
+		p.nextID++
+
+		p.workers++
+
+		p.started++
+
+		go p.worker(p.nextID)
+
+	}
+
+	select {
+
+	case p.wake <- struct{}{}:
+
+	default:
+
+	}
+
+}
+
+/*
+scale adds workers when the queued requests would wait longer than the target wait.
+The queued requests need ceil(depth * latency / targetWait) workers besides the busy ones to be served within the
+target wait, from the average latency of recent requests, and never more than one per queued request; before any
+request completed, one worker is added per queued request. Reserved workers are not counted as available, and
+are added to the desired size so that the queued requests of the lowest lanes can start.
+*/
+func (p *workerPool) scale() {
+
+	for range time.Tick(scaleInterval) {
+
+		p.mutex.Lock()
+
+		depth := len(p.inbound) + len(p.scheduled)
+
+		for _, queued := range p.queued {
+
+			depth += queued
+
+		}
+
+		available := p.workers - p.busy - p.reservedAbove(laneCount)
+
+		if depth == 0 || p.workers >= p.maxWorkers || available >= depth {
+
+			p.mutex.Unlock()
+
+			continue
+
+		}
+
+		needed := depth
+
+		if p.latency > 0 {
+
+			needed = min(depth, int(math.Ceil(float64(depth)*float64(p.latency)/float64(p.targetWait))))
+
+		}
+
+		// a request of the lowest lane only starts while the reserved workers stay free, so they come on top
+		desired := p.busy + needed + p.reservedAbove(laneCount)
+
+		if desired > p.maxWorkers {
+
+			desired = p.maxWorkers
+
+		}
+
+		if desired > p.workers {
+
+			logInstance.LogInfo(fmt.Sprintf("Scaling worker pool from %d to %d workers (%d queued, average latency %v)",
+				p.workers, desired, depth, p.latency.Round(time.Millisecond)))
+
+			p.grow(desired - p.workers)
+
+		}
+
+		p.mutex.Unlock()
+
+	}
+
+}
+
+/*
+worker processes the requests handed over by the dispatcher until it has been idle for the idle timeout while
+the pool is above its minimum size.
+*/
+func (p *workerPool) worker(ID int) {
+
+	idle := time.NewTimer(p.idleTimeout)
+
+	defer idle.Stop()
+
+	for {
+
+		select {
+
+		case item := <-p.work:
+
+			p.run(item)
+
+			p.done <- item.lane
+
+		case <-idle.C:
+
+			if p.retire(ID) {
+
+				return
+
+			}
+
+		}
+
+		if !idle.Stop() {
+
+			select {
+
+			case <-idle.C:
+
+			default:
+
+			}
+
+		}
+
+		idle.Reset(p.idleTimeout)
+
+	}
+
+}
+
+func (p *workerPool) run(item task) {
+
+	started := time.Now()
+
+	p.process(item.request, item.reply)
+
+	elapsed := time.Since(started)
+
+	p.mutex.Lock()
+
+	defer p.mutex.Unlock()
+
+	if p.latency == 0 {
+
+		p.latency = elapsed
+
+	} else {
+
+		p.latency = time.Duration(latencySmoothing*float64(elapsed) + (1-latencySmoothing)*float64(p.latency))
+
+	}
+
+}
+
+/*
+retire removes an idle worker from the pool unless the pool is at its minimum size.
+*/
+func (p *workerPool) retire(ID int) bool {
+
+	p.mutex.Lock()
+
+	defer p.mutex.Unlock()
+
+	if p.workers <= p.minWorkers {
+
+		return false
+
+	}
+
+	p.workers--
+
+	p.retired++
+
+	logInstance.LogInfo(fmt.Sprintf("Worker %d retired after %v idle, %d workers left", ID, p.idleTimeout, p.workers))
+
+	return true
+
+}
+
+/*
+Stats returns a snapshot of the pool state.
+*/
+func (p *workerPool) Stats() PoolStats {
+
+	p.mutex.Lock()
+
+	defer p.mutex.Unlock()
+
+	stats := PoolStats{
+
+		Workers: p.workers,
+
+		Busy: p.busy,
+
+		MinWorkers: p.minWorkers,
+
+		MaxWorkers: p.maxWorkers,
+
+		Queued: len(p.inbound) + len(p.scheduled),
+
+		AverageLatencyMs: math.Round(float64(p.latency)/float64(time.Millisecond)*100) / 100,
+
+		Started: p.started,
+
+		Retired: p.retired,
+
+		Lanes: make(map[string]LaneStats, laneCount),
+	}
+
+	for lane, name := range laneNames {
+
+		stats.Queued += p.queued[lane]
+
+		stats.Processed += p.processed[lane]
+
+		stats.Lanes[name] = LaneStats{Queued: p.queued[lane], Processed: p.processed[lane], Reserved: p.reserved[lane]}
+
+	}
+
+	return stats
+
+}
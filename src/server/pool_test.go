@@ -0,0 +1,103 @@
+package server
+
+import (
+	"NMS/src/util"
+	"testing"
+	"time"
+)
+
+func TestPoolGrowsPastReservedWorkersForSaturatedLane(t *testing.T) {
+
+	release := make(chan struct{})
+
+	defer close(release)
+
+	process := func(request string, reply func(string)) { <-release }
+
+	pool := newWorkerPool(util.WorkerPoolConfig{MinWorkers: 5, MaxWorkers: 50}, 100, make(chan string), process)
+
+	pool.start()
+
+	polls := 5
+
+	for i := 0; i < polls; i++ {
+
+		pool.submit(`{"RequestType": "provisioning"}`, nil)
+
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+
+	for {
+
+		stats := pool.Stats()
+
+		if stats.Busy == polls && stats.Lanes["polling"].Queued == 0 {
+
+			if reserved := pool.reservedAbove(laneCount); stats.Workers < polls+reserved {
+
+				t.Fatalf("%d workers for %d running polls, want at least %d with %d reserved", stats.Workers, polls, polls+reserved, reserved)
+
+			}
+
+			return
+
+		}
+
+		if time.Now().After(deadline) {
+
+			t.Fatalf("polls still waiting: workers=%d busy=%d queued=%d", stats.Workers, stats.Busy, stats.Lanes["polling"].Queued)
+
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+	}
+
+}
+
+func TestPoolKeepsReservedWorkerForControlLane(t *testing.T) {
+
+	release := make(chan struct{})
+
+	defer close(release)
+
+	answered := make(chan string, 1)
+
+	process := func(request string, reply func(string)) {
+
+		if reply != nil {
+
+			reply(request)
+
+			return
+
+		}
+
+		<-release
+
+	}
+
+	pool := newWorkerPool(util.WorkerPoolConfig{MinWorkers: 3, MaxWorkers: 3}, 100, make(chan string), process)
+
+	pool.start()
+
+	for i := 0; i < 10; i++ {
+
+		pool.submit(`{"RequestType": "provisioning"}`, nil)
+
+	}
+
+	pool.submit(`{"RequestType": "health"}`, func(response string) { answered <- response })
+
+	select {
+
+	case <-answered:
+
+	case <-time.After(3 * time.Second):
+
+		t.Fatalf("health request not served while the polling lane is saturated: %+v", pool.Stats())
+
+	}
+
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"NMS/src/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pebbe/zmq4"
+)
+
+// topicPlaceholder stands for a topic part that a message lacks, e.g. the ip of a health response.
+const topicPlaceholder = "-"
+
+/*
+PublisherStats reports the activity of the PUB socket in the health response.
+Dropped counts the messages discarded because the publisher could not keep up; it never slows down the workers.
+*/
+type PublisherStats struct {
+	Address    string `json:"address"`
+	Published  uint64 `json:"published"`
+	Dropped    uint64 `json:"dropped"`
+	SendErrors uint64 `json:"sendErrors"`
+}
+
+/*
+publisher fans out the responses and events on a PUB socket, so that dashboards, archivers and alerting services
+can subscribe to the topics they need independently of the controller reading the PUSH socket.
+
+Every message is sent as two frames, the topic and the JSON message. Topics are dot-separated so that subscribers
+can filter by prefix, always have the same parts for a kind of message, with - for a part the message lacks,
+and end with a dot:
+  - metrics.<SystemType>.<ip>. for successful provisioning results
+  - results.<RequestType>.<SystemType>.<ip>. for the other responses
+  - alerts.<severity>.<SystemType>.<ip>. for threshold alerts and clears
+  - events.<event>.<ip>. for the other events, such as state_change and circuit_change
+
+ZeroMQ matches subscriptions by prefix, so the trailing dot is what restricts a subscription to one host:
+metrics.windows.10.0.0.1. receives the metrics of 10.0.0.1 only, while metrics.windows.10.0.0.1 would also
+receive those of 10.0.0.10 to 10.0.0.19 and 10.0.0.100 to 10.0.0.199.
+*/
+type publisher struct {
+	address  string
+	messages chan [2]string
+	mutex    sync.Mutex
+	stats    PublisherStats
+}
+
+func newPublisher(address string, queueSize int) *publisher {
+
+	if queueSize <= 0 {
+
+		queueSize = DefaultQueueSize
+
+	}
+
+	return &publisher{
+
+		address: address,
+
+		messages: make(chan [2]string, queueSize),
+
+		stats: PublisherStats{Address: address},
+	}
+
+}
+
+/*
+publish queues a response or event for the PUB socket under topic, dropping it if the queue is full.
+*/
+func (p *publisher) publish(topic string, message string) {
+
+	select {
+
+	case p.messages <- [2]string{topic, message}:
+
+	default:
+
+		p.mutex.Lock()
+
+		p.stats.Dropped++
+
+		p.mutex.Unlock()
+
+	}
+
+}
+
+/*
+serve binds the PUB socket and sends the queued messages.
+*/
+func (p *publisher) serve() {
+
+	socket, err := zmq4.NewSocket(zmq4.PUB)
+
+	if err != nil {
+
+		logInstance.LogError(errors.New("Publisher failed to create PUB socket: " + err.Error()))
+
+		return
+
+	}
+
+	defer socket.Close()
+
+	if hwm := util.LoadConfig().Transport.SendHighWaterMark; hwm > 0 {
+
+		if err := socket.SetSndhwm(hwm); err != nil {
+
+			logInstance.LogError(fmt.Errorf("Publisher failed to set send high-water mark: %v", err))
+
+		}
+
+	}
+
+	if err := secureServer(socket); err != nil {
+
+		logInstance.LogError(errors.New("Publisher failed to enable CURVE on PUB socket: " + err.Error()))
+
+		return
+
+	}
+
+	if err := socket.Bind(p.address); err != nil {
+
+		logInstance.LogError(errors.New("Publisher failed to bind PUB socket: " + err.Error()))
+
+		return
+
+	}
+
+	logInstance.LogInfo("Publisher is ready and bound to PUB socket on " + p.address)
+
+	for frames := range p.messages {
+
+		_, err := socket.SendMessage(frames[0], frames[1])
+
+		p.mutex.Lock()
+
+		if err != nil {
+
+			p.stats.SendErrors++
+
+		} else {
+
+			p.stats.Published++
+
+		}
+
+		p.mutex.Unlock()
+
+		if err != nil {
+
+			logInstance.LogError(fmt.Errorf("Publisher failed to send message: %v", err))
+
+		}
+
+	}
+
+}
+
+/*
+Stats returns a snapshot of the publisher statistics.
+*/
+func (p *publisher) Stats() PublisherStats {
+
+	p.mutex.Lock()
+
+	defer p.mutex.Unlock()
+
+	return p.stats
+
+}
+
+/*
+topicOf returns the topic of a response or event, in the layout described on publisher.
+*/
+func topicOf(message string) string {
+
+	var header struct {
+		Event       string `json:"event"`
+		Severity    string `json:"severity"`
+		RequestType string `json:"RequestType"`
+		SystemType  string `json:"SystemType"`
+		IP          string `json:"ip"`
+		Status      string `json:"status"`
+	}
+
+	json.Unmarshal([]byte(message), &header)
+
+	var parts []string
+
+	switch {
+
+	case header.Event == util.EventTypeAlert || header.Event == util.EventTypeClear:
+
+		parts = []string{"alerts", header.Severity, header.SystemType, header.IP}
+
+	case header.Event != "":
+
+		parts = []string{"events", header.Event, header.IP}
+
+	case header.RequestType == RequestTypeProvisioning && header.Status == "success":
+
+		parts = []string{"metrics", header.SystemType, header.IP}
+
+	default:
+
+		parts = []string{"results", header.RequestType, header.SystemType, header.IP}
+
+	}
+
+	for i, part := range parts {
+
+		if parts[i] = strings.TrimSpace(part); parts[i] == "" {
+
+			parts[i] = topicPlaceholder
+
+		}
+
+	}
+
+	return strings.Join(parts, ".") + "."
+
+}
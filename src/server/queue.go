@@ -0,0 +1,450 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop-oldest"
+	OverflowSpill      = "spill"
+	DefaultQueueSize   = 100
+	DefaultSpillPath   = "data/spill.jsonl"
+	spillDrainInterval = time.Second
+)
+
+/*
+QueueStats reports the activity of the result queue in the health response.
+Backpressure is true from the moment a result finds the queue full until the queue is back to half its capacity.
+*/
+type QueueStats struct {
+	Policy       string `json:"policy"`
+	Capacity     int    `json:"capacity"`
+	Depth        int    `json:"depth"`
+	Enqueued     uint64 `json:"enqueued"`
+	Blocked      uint64 `json:"blocked"`
+	BlockedMs    int64  `json:"blockedMs"`
+	Dropped      uint64 `json:"dropped"`
+	Spilled      uint64 `json:"spilled"`
+	Unspilled    uint64 `json:"unspilled"`
+	SpillPending int    `json:"spillPending"`
+	SendErrors   uint64 `json:"sendErrors"`
+	Backpressure bool   `json:"backpressure"`
+}
+
+/*
+resultQueue is the bounded queue between the workers and the sender, applying the overflow policy when it is full.
+*/
+type resultQueue struct {
+	messages  chan string
+	policy    string
+	spillPath string
+	mutex     sync.Mutex
+	stats     QueueStats
+	since     time.Time
+}
+
+/*
+newResultQueue returns a queue of size messages. With the spill policy, results left in the spill file, or in the
+file of a drain that was interrupted, by a previous run are queued again once there is room.
+*/
+func newResultQueue(size int, policy string, spillPath string) *resultQueue {
+
+	if size <= 0 {
+
+		size = DefaultQueueSize
+
+	}
+
+	switch policy {
+
+	case OverflowBlock, OverflowDropOldest, OverflowSpill:
+
+	case "":
+
+		policy = OverflowBlock
+
+	default:
+
+		logInstance.LogWarning(fmt.Sprintf("Unknown overflow policy %q, using %s", policy, OverflowBlock))
+
+		policy = OverflowBlock
+
+	}
+
+	if spillPath == "" {
+
+		spillPath = DefaultSpillPath
+
+	}
+
+	queue := &resultQueue{
+
+		messages: make(chan string, size),
+
+		policy: policy,
+
+		spillPath: spillPath,
+
+		stats: QueueStats{Policy: policy, Capacity: size},
+	}
+
+	if policy == OverflowSpill {
+
+		queue.stats.SpillPending = countLines(spillPath) + countLines(queue.drainingPath())
+
+		go queue.drainSpill()
+
+	}
+
+	return queue
+
+}
+
+/*
+push queues a message, applying the overflow policy when the queue is full.
+*/
+func (q *resultQueue) push(message string) {
+
+	select {
+
+	case q.messages <- message:
+
+		q.enqueued()
+
+		return
+
+	default:
+
+	}
+
+	q.congested()
+
+	switch q.policy {
+
+	case OverflowDropOldest:
+
+		for {
+
+			select {
+
+			case q.messages <- message:
+
+				q.enqueued()
+
+				return
+
+			default:
+
+			}
+
+			select {
+
+			case <-q.messages:
+
+				q.mutex.Lock()
+
+				q.stats.Dropped++
+
+				q.mutex.Unlock()
+
+			default:
+
+			}
+
+		}
+
+	case OverflowSpill:
+
+		err := q.spill(message)
+
+		if err == nil {
+
+			return
+
+		}
+
+		logInstance.LogError(fmt.Errorf("failed to spill result to %s, blocking instead: %v", q.spillPath, err))
+
+	}
+
+	started := time.Now()
+
+	q.messages <- message
+
+	q.mutex.Lock()
+
+	q.stats.Blocked++
+
+	q.stats.BlockedMs += time.Since(started).Milliseconds()
+
+	q.mutex.Unlock()
+
+	q.enqueued()
+
+}
+
+func (q *resultQueue) enqueued() {
+
+	q.mutex.Lock()
+
+	defer q.mutex.Unlock()
+
+	q.stats.Enqueued++
+
+	q.relieved()
+
+}
+
+/*
+relieved logs the end of a backpressure episode once the queue is back to half its capacity.
+The caller must hold the mutex.
+*/
+func (q *resultQueue) relieved() {
+
+	if q.stats.Backpressure && len(q.messages) <= cap(q.messages)/2 {
+
+		q.stats.Backpressure = false
+
+		logInstance.LogInfo(fmt.Sprintf("Result queue backpressure ended after %v: dropped=%d spilled=%d blocked=%d",
+			time.Since(q.since).Round(time.Millisecond), q.stats.Dropped, q.stats.Spilled, q.stats.Blocked))
+
+	}
+
+}
+
+/*
+congested records that a message found the queue full and logs the start of a backpressure episode.
+*/
+func (q *resultQueue) congested() {
+
+	q.mutex.Lock()
+
+	defer q.mutex.Unlock()
+
+	if q.stats.Backpressure {
+
+		return
+
+	}
+
+	q.stats.Backpressure = true
+
+	q.since = time.Now()
+
+	logInstance.LogWarning(fmt.Sprintf("Result queue full (capacity %d), applying overflow policy %s", cap(q.messages), q.policy))
+
+}
+
+/*
+spill appends a message to the spill file. Messages are JSON-encoded strings and never contain a newline.
+*/
+func (q *resultQueue) spill(message string) error {
+
+	q.mutex.Lock()
+
+	defer q.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(q.spillPath), 0o700); err != nil {
+
+		return err
+
+	}
+
+	file, err := os.OpenFile(q.spillPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+
+	if err != nil {
+
+		return err
+
+	}
+
+	defer file.Close()
+
+	if _, err := file.WriteString(message + "\n"); err != nil {
+
+		return err
+
+	}
+
+	q.stats.Spilled++
+
+	q.stats.SpillPending++
+
+	return nil
+
+}
+
+/*
+drainingPath returns the path of the spill file while its messages are moved back to the queue.
+*/
+func (q *resultQueue) drainingPath() string {
+
+	return q.spillPath + ".draining"
+
+}
+
+/*
+drainSpill moves spilled messages back to the queue once it is no more than half full.
+The spill file is renamed before its messages are queued and removed only once all of them have been handed to
+the queue, so a batch interrupted by a crash is drained again on the next start: messages are delivered at least
+once. Messages spilled while a batch is being drained go to a new file, so results may be delivered out of order
+across a spill.
+*/
+func (q *resultQueue) drainSpill() {
+
+	draining := q.drainingPath()
+
+	for range time.Tick(spillDrainInterval) {
+
+		q.mutex.Lock()
+
+		if q.stats.SpillPending == 0 || len(q.messages) > cap(q.messages)/2 {
+
+			q.mutex.Unlock()
+
+			continue
+
+		}
+
+		// a batch left by an interrupted drain is finished before the spill file is taken
+		_, err := os.Stat(draining)
+
+		if os.IsNotExist(err) {
+
+			err = os.Rename(q.spillPath, draining)
+
+		}
+
+		if os.IsNotExist(err) {
+
+			// both files are gone, e.g. removed by hand, so nothing is pending any more
+			q.stats.SpillPending = 0
+
+			q.mutex.Unlock()
+
+			continue
+
+		}
+
+		var data []byte
+
+		if err == nil {
+
+			data, err = os.ReadFile(draining)
+
+		}
+
+		q.mutex.Unlock()
+
+		if err != nil {
+
+			logInstance.LogError(fmt.Errorf("failed to read spill file %s: %v", q.spillPath, err))
+
+			continue
+
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+
+			if line == "" {
+
+				continue
+
+			}
+
+			q.messages <- line
+
+			q.mutex.Lock()
+
+			q.stats.Unspilled++
+
+			q.stats.SpillPending--
+
+			q.mutex.Unlock()
+
+		}
+
+		if err := os.Remove(draining); err != nil {
+
+			logInstance.LogError(fmt.Errorf("failed to remove drained spill file %s: %v", draining, err))
+
+		}
+
+	}
+
+}
+
+/*
+delivered records the outcome of sending a message taken from the queue.
+*/
+func (q *resultQueue) delivered(err error) {
+
+	q.mutex.Lock()
+
+	defer q.mutex.Unlock()
+
+	if err != nil {
+
+		q.stats.SendErrors++
+
+	}
+
+	q.relieved()
+
+}
+
+/*
+Stats returns a snapshot of the queue statistics.
+*/
+func (q *resultQueue) Stats() QueueStats {
+
+	q.mutex.Lock()
+
+	defer q.mutex.Unlock()
+
+	stats := q.stats
+
+	stats.Depth = len(q.messages)
+
+	return stats
+
+}
+
+func countLines(path string) int {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+
+		return 0
+
+	}
+
+	defer file.Close()
+
+	count := 0
+
+	scanner := bufio.NewScanner(file)
+
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+
+		if scanner.Text() != "" {
+
+			count++
+
+		}
+
+	}
+
+	return count
+
+}
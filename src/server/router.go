@@ -0,0 +1,362 @@
+package server
+
+import (
+	"NMS/src/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pebbe/zmq4"
+)
+
+// routerRepliesAddress carries the responses of the workers to the goroutine that owns the ROUTER socket.
+const routerRepliesAddress = "inproc://router-replies"
+
+/*
+RouterStats reports the activity of the ROUTER socket in the health response.
+Rejected counts the requests answered with a queue_full error because the worker pool queue was full.
+*/
+type RouterStats struct {
+	Address    string `json:"address"`
+	Received   uint64 `json:"received"`
+	Replied    uint64 `json:"replied"`
+	Rejected   uint64 `json:"rejected"`
+	SendErrors uint64 `json:"sendErrors"`
+}
+
+/*
+routerServer serves requests from clients connected with REQ or DEALER sockets, such as CLI tools, and sends
+every response back to the client that sent the request, encoded like the responses of the PUSH socket.
+
+ZeroMQ sockets must not be shared between goroutines, so the workers hand their responses over a channel to a
+forwarder that passes them to the serving goroutine through an inproc socket polled together with the ROUTER socket.
+*/
+type routerServer struct {
+	address string
+	replies chan []string
+	mutex   sync.Mutex
+	stats   RouterStats
+}
+
+func newRouterServer(address string, queueSize int) *routerServer {
+
+	if queueSize <= 0 {
+
+		queueSize = DefaultQueueSize
+
+	}
+
+	return &routerServer{
+
+		address: address,
+
+		replies: make(chan []string, queueSize),
+
+		stats: RouterStats{Address: address},
+	}
+
+}
+
+/*
+serve binds the ROUTER socket and relays requests to the worker pool and responses to their clients.
+A request is the last frame of a message; the frames before it (the client identity and, for REQ clients,
+the empty delimiter) are returned unchanged in front of the response.
+*/
+func (r *routerServer) serve() {
+
+	socket, err := zmq4.NewSocket(zmq4.ROUTER)
+
+	if err != nil {
+
+		logInstance.LogError(errors.New("Failed to create ROUTER socket: " + err.Error()))
+
+		return
+
+	}
+
+	defer socket.Close()
+
+	transport := util.LoadConfig().Transport
+
+	if transport.ReceiveHighWaterMark > 0 {
+
+		if err := socket.SetRcvhwm(transport.ReceiveHighWaterMark); err != nil {
+
+			logInstance.LogError(fmt.Errorf("Router failed to set receive high-water mark: %v", err))
+
+		}
+
+	}
+
+	if transport.SendHighWaterMark > 0 {
+
+		if err := socket.SetSndhwm(transport.SendHighWaterMark); err != nil {
+
+			logInstance.LogError(fmt.Errorf("Router failed to set send high-water mark: %v", err))
+
+		}
+
+	}
+
+	if err := secureServer(socket); err != nil {
+
+		logInstance.LogError(errors.New("Failed to enable CURVE on ROUTER socket: " + err.Error()))
+
+		return
+
+	}
+
+	if err := socket.Bind(r.address); err != nil {
+
+		logInstance.LogError(errors.New("Failed to bind ROUTER socket: " + err.Error()))
+
+		return
+
+	}
+
+	replies, err := zmq4.NewSocket(zmq4.PULL)
+
+	if err != nil {
+
+		logInstance.LogError(errors.New("Failed to create router reply socket: " + err.Error()))
+
+		return
+
+	}
+
+	defer replies.Close()
+
+	if err := replies.Bind(routerRepliesAddress); err != nil {
+
+		logInstance.LogError(errors.New("Failed to bind router reply socket: " + err.Error()))
+
+		return
+
+	}
+
+	go r.forward()
+
+	poller := zmq4.NewPoller()
+
+	poller.Add(socket, zmq4.POLLIN)
+
+	poller.Add(replies, zmq4.POLLIN)
+
+	logInstance.LogInfo("Router is ready and bound to ROUTER socket on " + r.address)
+
+	for {
+
+		polled, err := poller.Poll(-1)
+
+		if err != nil {
+
+			logInstance.LogError(fmt.Errorf("Router failed to poll sockets: %v", err))
+
+			continue
+
+		}
+
+		for _, item := range polled {
+
+			switch item.Socket {
+
+			case socket:
+
+				r.receive(socket)
+
+			case replies:
+
+				frames, err := replies.RecvMessage(0)
+
+				if err != nil {
+
+					logInstance.LogError(fmt.Errorf("Router failed to receive response: %v", err))
+
+					continue
+
+				}
+
+				r.send(socket, frames)
+
+			}
+
+		}
+
+	}
+
+}
+
+/*
+receive reads a request from the ROUTER socket and hands it to the worker pool. The router never waits for the
+pool, as it also delivers the responses the workers wait on; when the pool queue is full, the client gets a
+queue_full error at once.
+*/
+func (r *routerServer) receive(socket *zmq4.Socket) {
+
+	frames, err := socket.RecvMessage(0)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("Router failed to receive message: %v", err))
+
+		return
+
+	}
+
+	if len(frames) < 2 {
+
+		logInstance.LogWarning(fmt.Sprintf("Router ignored a message of %d frames without identity", len(frames)))
+
+		return
+
+	}
+
+	envelope, request := frames[:len(frames)-1], frames[len(frames)-1]
+
+	r.mutex.Lock()
+
+	r.stats.Received++
+
+	r.mutex.Unlock()
+
+	reply := func(response string) {
+
+		r.replies <- append(append([]string{}, envelope...), encodedReply(response))
+
+	}
+
+	if pool.trySubmit(request, reply) {
+
+		return
+
+	}
+
+	r.mutex.Lock()
+
+	r.stats.Rejected++
+
+	r.mutex.Unlock()
+
+	logInstance.LogWarning("Worker pool queue full, rejecting request received on the ROUTER socket")
+
+	r.send(socket, append(envelope, encodedReply(queueFullResponse(request))))
+
+}
+
+/*
+forward passes the responses of the workers to the serving goroutine.
+*/
+func (r *routerServer) forward() {
+
+	socket, err := zmq4.NewSocket(zmq4.PUSH)
+
+	if err != nil {
+
+		logInstance.LogError(errors.New("Failed to create router forward socket: " + err.Error()))
+
+		return
+
+	}
+
+	defer socket.Close()
+
+	if err := socket.Connect(routerRepliesAddress); err != nil {
+
+		logInstance.LogError(errors.New("Failed to connect router forward socket: " + err.Error()))
+
+		return
+
+	}
+
+	for frames := range r.replies {
+
+		if _, err := socket.SendMessage(frames); err != nil {
+
+			logInstance.LogError(fmt.Errorf("Router failed to forward response: %v", err))
+
+		}
+
+	}
+
+}
+
+func (r *routerServer) send(socket *zmq4.Socket, frames []string) {
+
+	_, err := socket.SendMessage(frames)
+
+	r.mutex.Lock()
+
+	if err != nil {
+
+		r.stats.SendErrors++
+
+	} else {
+
+		r.stats.Replied++
+
+	}
+
+	r.mutex.Unlock()
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("Router failed to send response: %v", err))
+
+	}
+
+}
+
+/*
+Stats returns a snapshot of the router statistics.
+*/
+func (r *routerServer) Stats() RouterStats {
+
+	r.mutex.Lock()
+
+	defer r.mutex.Unlock()
+
+	return r.stats
+
+}
+
+/*
+encodedReply returns a response encoded for the ROUTER socket. A response that cannot be encoded is sent as it is,
+as the client waits for a reply.
+*/
+func encodedReply(response string) string {
+
+	encoded, err := encodeReply(response)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("Router failed to encode response: %v", err))
+
+		return response
+
+	}
+
+	return encoded
+
+}
+
+/*
+queueFullResponse returns the response to a request that could not be queued.
+*/
+func queueFullResponse(request string) string {
+
+	responseData := make(map[string]interface{})
+
+	json.Unmarshal([]byte(request), &responseData)
+
+	util.RedactCredentials(responseData)
+
+	responseData["errors"] = map[string]interface{}{"queue_full": "Request queue is full, try again later"}
+
+	responseData["status"] = "fail"
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"NMS/src/util"
+	"errors"
+	"fmt"
+
+	"github.com/pebbe/zmq4"
+)
+
+// curveDomain is the ZAP domain of the sockets bound by the engine.
+const curveDomain = "nms"
+
+/*
+curveKeys holds the engine key pair and the controller public key once security is started.
+It is nil when CURVE is disabled.
+*/
+type curveKeys struct {
+	public           string
+	secret           string
+	controllerPublic string
+}
+
+var curve *curveKeys
+
+/*
+startSecurity validates the CURVE keys of config and starts the ZAP handler that authenticates the clients of the
+bound sockets against the allow-listed public keys. It does nothing when CURVE is disabled.
+*/
+func startSecurity(config util.SecurityConfig) error {
+
+	if !config.Curve {
+
+		return nil
+
+	}
+
+	if !zmq4.HasCurve() {
+
+		return errors.New("CURVE is enabled but libzmq was built without CURVE support")
+
+	}
+
+	if config.SecretKey == "" || config.ControllerPublicKey == "" {
+
+		return errors.New("CURVE requires secretKey and controllerPublicKey")
+
+	}
+
+	public, err := zmq4.AuthCurvePublic(config.SecretKey)
+
+	if err != nil {
+
+		return fmt.Errorf("invalid secretKey: %v", err)
+
+	}
+
+	if config.PublicKey != "" && config.PublicKey != public {
+
+		return errors.New("publicKey does not match secretKey")
+
+	}
+
+	if len(config.AllowedClientKeys) == 0 {
+
+		logInstance.LogWarning("CURVE is enabled without allowedClientKeys, no client can connect to the bound sockets")
+
+	}
+
+	if err := zmq4.AuthStart(); err != nil {
+
+		return fmt.Errorf("failed to start ZAP handler: %v", err)
+
+	}
+
+	zmq4.AuthCurveAdd(curveDomain, config.AllowedClientKeys...)
+
+	curve = &curveKeys{public: public, secret: config.SecretKey, controllerPublic: config.ControllerPublicKey}
+
+	logInstance.LogInfo(fmt.Sprintf("CURVE security enabled with public key %s and %d allowed client keys", public, len(config.AllowedClientKeys)))
+
+	return nil
+
+}
+
+/*
+secureServer makes a socket that the engine binds a CURVE server, if CURVE is enabled. It must be called before Bind.
+*/
+func secureServer(socket *zmq4.Socket) error {
+
+	if curve == nil {
+
+		return nil
+
+	}
+
+	return socket.ServerAuthCurve(curveDomain, curve.secret)
+
+}
+
+/*
+secureClient makes a socket that the engine connects a CURVE client of the controller, if CURVE is enabled.
+It must be called before Connect.
+*/
+func secureClient(socket *zmq4.Socket) error {
+
+	if curve == nil {
+
+		return nil
+
+	}
+
+	return socket.ClientAuthCurve(curve.controllerPublic, curve.public, curve.secret)
+
+}
+
+/*
+GenerateCurveKeyPair returns a new Z85-encoded CURVE key pair for the security section of the configuration.
+*/
+func GenerateCurveKeyPair() (string, string, error) {
+
+	if !zmq4.HasCurve() {
+
+		return "", "", errors.New("libzmq was built without CURVE support")
+
+	}
+
+	return zmq4.NewCurveKeypair()
+
+}
@@ -1,12 +1,26 @@
 package server
 
 import (
+	"NMS/src/alerting"
+	"NMS/src/availability"
+	"NMS/src/breaker"
+	"NMS/src/limiter"
+	"NMS/src/plugin/certificate"
+	"NMS/src/plugin/database"
+	"NMS/src/plugin/dns"
+	"NMS/src/plugin/httpcheck"
+	"NMS/src/plugin/ping"
 	"NMS/src/plugin/windows"
+	"NMS/src/scheduler"
 	"NMS/src/util"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"sync"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pebbe/zmq4"
 )
@@ -20,27 +34,274 @@ type Request struct {
 }
 
 var (
-	logInstance = util.InitializeLogger()
-	resultChan  = make(chan string, 100)
+	logInstance   = util.InitializeLogger()
+	results       *resultQueue
+	scheduledChan chan string
+	pool          *workerPool
+	publications  *publisher
 )
 
 const (
 	inBoundAddress          = "tcp://127.0.0.1:5555" // Connect to Java ZMQ server
 	outBoundAddress         = "tcp://127.0.0.1:5556" // If needed, otherwise remove
-	workerCount             = 5
 	RequestTypeDiscovery    = "discovery"
 	RequestTypeProvisioning = "provisioning"
 	RequestTypeHealth       = "health"
+	RequestTypeInventory    = "inventory"
+	RequestTypeExecute      = "execute"
+	RequestTypeSchedule     = "schedule"
+	RequestTypeListMonitors = "listMonitors"
+	RequestTypeStatus       = "status"
 )
 
-var wg sync.WaitGroup
+/*
+Plugin handlers of the discovery and provisioning requests, by SystemType.
+Requests of other system types go to the Windows plugin, which reports them as unknown.
+*/
+var (
+	discoveryHandlers = map[string]func(map[string]interface{}) string{
+		windows.SystemTypeWindows:         windows.HandleDiscovery,
+		ping.SystemTypePing:               ping.HandleProvisioning,
+		httpcheck.SystemTypeHTTP:          httpcheck.HandleProvisioning,
+		certificate.SystemTypeCertificate: certificate.HandleProvisioning,
+		dns.SystemTypeDNS:                 dns.HandleProvisioning,
+		database.SystemTypePostgreSQL:     database.HandleProvisioning,
+		database.SystemTypeMySQL:          database.HandleProvisioning,
+	}
+
+	provisioningHandlers = map[string]func(map[string]interface{}) string{
+		windows.SystemTypeWindows:         windows.HandleProvisioning,
+		ping.SystemTypePing:               ping.HandleProvisioning,
+		httpcheck.SystemTypeHTTP:          httpcheck.HandleProvisioning,
+		certificate.SystemTypeCertificate: certificate.HandleProvisioning,
+		dns.SystemTypeDNS:                 dns.HandleProvisioning,
+		database.SystemTypePostgreSQL:     database.HandleProvisioning,
+		database.SystemTypeMySQL:          database.HandleProvisioning,
+	}
+)
+
+/*
+dispatchPlugin routes a request to the handler registered for its SystemType, or to fallback.
+*/
+func dispatchPlugin(handlers map[string]func(map[string]interface{}) string, fallback func(map[string]interface{}) string, responseData map[string]interface{}) string {
+
+	systemType, _ := responseData["SystemType"].(string)
+
+	if handler, ok := handlers[systemType]; ok {
+
+		return handler(responseData)
+
+	}
+
+	return fallback(responseData)
+
+}
+
+/*
+limited runs a plugin handler once the target host has a free slot and the rate limits allow it.
+Requests that cannot be admitted before their deadline fail with a deadline_exceeded error.
+*/
+func limited(handler func(map[string]interface{}) string, responseData map[string]interface{}) string {
+
+	systemType, _ := responseData["SystemType"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), limiter.Deadline(responseData))
+
+	release, err := limiter.Default().Acquire(ctx, limiter.Host(responseData), systemType)
+
+	cancel()
+
+	if err != nil {
+
+		logInstance.LogWarning(err.Error())
+
+		errorData, exists := responseData["errors"].(map[string]interface{})
+
+		if !exists {
+
+			errorData = make(map[string]interface{})
+
+		}
+
+		errorData["deadline_exceeded"] = err.Error()
+
+		responseData["errors"] = errorData
+
+		responseData["status"] = "fail"
+
+		jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+		return string(jsonResponse)
+
+	}
+
+	defer release()
+
+	return handler(responseData)
+
+}
+
+/*
+circuitOf returns the host and service whose circuit breaker guards a request. The host is the ip of the request,
+or the host of its url as for the HTTP checks. The service is the SystemType, with the port of the request or of
+its url, so that a failing service does not open the circuit of the other services of the host.
+*/
+func circuitOf(responseData map[string]interface{}) (string, string) {
+
+	host := limiter.Host(responseData)
+
+	service, _ := responseData["SystemType"].(string)
+
+	rawURL, _ := responseData["url"].(string)
+
+	if port, ok := responseData["port"].(float64); ok {
+
+		service = fmt.Sprintf("%s:%d", service, int(port))
+
+	} else if port := portOfURL(rawURL); port > 0 {
+
+		service = fmt.Sprintf("%s:%d", service, port)
+
+	}
+
+	return host, service
+
+}
+
+/*
+portOfURL returns the port of rawURL, explicit or implied by its http or https scheme, or 0.
+*/
+func portOfURL(rawURL string) int {
+
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil || rawURL == "" {
+
+		return 0
+
+	}
+
+	if port, err := strconv.Atoi(parsed.Port()); err == nil {
+
+		return port
+
+	}
+
+	switch parsed.Scheme {
+
+	case "https":
+
+		return 443
+
+	case "http":
+
+		return 80
+
+	}
+
+	return 0
+
+}
+
+/*
+runPlugin runs a plugin request within the limits and retries it according to the retry policy of its SystemType
+while it fails with a retryable error_class. Every attempt starts from a fresh copy of the request, and the number
+of attempts is reported as attempts in the response.
+
+Execute requests run arbitrary commands that may not be safe to run twice, so they are only retried when the
+request sets retry to true.
+
+The request fails at once with a circuit_open error if the circuit breaker of its target is open. Otherwise the
+outcome of the last attempt is reported to the breaker, so a request counts once however many times it is retried.
+*/
+func runPlugin(requestStr string, responseData map[string]interface{}, handler func(map[string]interface{}) string) string {
+
+	systemType, _ := responseData["SystemType"].(string)
+
+	policy := util.RetryPolicyFor(systemType)
+
+	if retry, _ := responseData["retry"].(bool); responseData["RequestType"] == RequestTypeExecute && !retry {
+
+		policy.MaxAttempts = 1
+
+	}
+
+	done := func(bool, string) {}
+
+	if host, service := circuitOf(responseData); host != "" {
+
+		allowed, err := breaker.Default().Allow(host, service)
+
+		if err != nil {
+
+			errorData, exists := responseData["errors"].(map[string]interface{})
+
+			if !exists {
+
+				errorData = make(map[string]interface{})
+
+			}
+
+			errorData["circuit_open"] = err.Error()
+
+			responseData["errors"] = errorData
+
+			responseData["status"] = "fail"
+
+			jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+			return string(jsonResponse)
+
+		}
+
+		done = allowed
+
+	}
+
+	for attempt := 1; ; attempt++ {
+
+		responseData["attempts"] = attempt
+
+		response := limited(handler, responseData)
+
+		var outcome struct {
+			Status string                 `json:"status"`
+			Errors map[string]interface{} `json:"errors"`
+		}
+
+		json.Unmarshal([]byte(response), &outcome)
+
+		errorClass, _ := outcome.Errors["error_class"].(string)
+
+		if outcome.Status == "success" || attempt >= policy.MaxAttempts || !policy.Retries(errorClass) {
+
+			done(outcome.Status == "success", errorClass)
+
+			return response
+
+		}
+
+		delay := policy.Backoff(attempt)
+
+		logInstance.LogWarning(fmt.Sprintf("Attempt %d of %s request for %v failed (%s), retrying in %v",
+			attempt, systemType, responseData["ip"], errorClass, delay.Round(time.Millisecond)))
+
+		time.Sleep(delay)
+
+		responseData = map[string]interface{}{"errors": map[string]interface{}{}}
+
+		json.Unmarshal([]byte(requestStr), &responseData)
+
+	}
+
+}
 
 /*
 handleRequest processes incoming JSON requests and routes them to the appropriate handler based on the request type.
 
 Parameters:
 - requestStr: A JSON string containing the request details. It should include:
-  - RequestType: The type of request (e.g., "discovery", "provisioning").
+  - RequestType: The type of request (e.g., "discovery", "provisioning", "inventory").
   - SystemType: The type of system to interact with (e.g., "windows").
   - Ip: The IP address of the target system.
   - Username: The username for authentication.
@@ -109,13 +370,51 @@ func handleRequest(requestStr string) string {
 
 		logInstance.LogInfo("Handling discovery request")
 
-		return windows.HandleDiscovery(responseData)
+		return runPlugin(requestStr, responseData, func(data map[string]interface{}) string {
+
+			return dispatchPlugin(discoveryHandlers, windows.HandleDiscovery, data)
+
+		})
 
 	case RequestTypeProvisioning:
 
 		logInstance.LogInfo("Handling provisioning request")
 
-		return windows.HandleProvisioning(responseData)
+		return runPlugin(requestStr, responseData, func(data map[string]interface{}) string {
+
+			return dispatchPlugin(provisioningHandlers, windows.HandleProvisioning, data)
+
+		})
+
+	case RequestTypeInventory:
+
+		logInstance.LogInfo("Handling inventory request")
+
+		return runPlugin(requestStr, responseData, windows.HandleInventory)
+
+	case RequestTypeExecute:
+
+		logInstance.LogInfo("Handling execute request")
+
+		return runPlugin(requestStr, responseData, windows.HandleExecute)
+
+	case RequestTypeSchedule:
+
+		logInstance.LogInfo("Handling schedule request")
+
+		return scheduler.HandleSchedule(responseData)
+
+	case RequestTypeListMonitors:
+
+		logInstance.LogInfo("Handling listMonitors request")
+
+		return scheduler.HandleListMonitors(responseData)
+
+	case RequestTypeStatus:
+
+		logInstance.LogInfo("Handling status request")
+
+		return availability.HandleStatus(responseData)
 
 	default:
 
@@ -133,63 +432,277 @@ func handleRequest(requestStr string) string {
 
 }
 
-func worker(ID int, wg *sync.WaitGroup) {
+/*
+processRequest handles a single request, delivers its response and post-processes it.
+The response goes to reply when the request came in on the ROUTER socket, and to the sender otherwise;
+it is also published on the PUB socket and to the gRPC subscribers. Credentials echoed from the request are
+redacted first, as none of these consumers needs them.
+*/
+func processRequest(msg string, reply func(string)) {
+
+	response := redactResponse(handleRequest(msg))
+
+	if reply != nil {
+
+		reply(response)
+
+	} else {
+
+		queueResult(response)
+
+	}
+
+	publish(response)
+
+	postProcess(response)
+
+}
+
+/*
+redactResponse returns response with the credentials of the request redacted. Numbers are kept as they are,
+so that large counters do not lose precision.
+*/
+func redactResponse(response string) string {
+
+	var responseData map[string]interface{}
 
-	socket, err := zmq4.NewSocket(zmq4.PULL)
+	decoder := json.NewDecoder(strings.NewReader(response))
+
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&responseData); err != nil {
+
+		return response
+
+	}
+
+	util.RedactCredentials(responseData)
+
+	jsonResponse, _ := json.MarshalIndent(responseData, "", "  ")
+
+	return string(jsonResponse)
+
+}
+
+/*
+queueResult queues a response or event for the sender.
+*/
+func queueResult(message string) {
+
+	jsonData, err := encodeReply(message)
 
 	if err != nil {
 
-		logInstance.LogError(errors.New("Failed to create router socket: " + err.Error()))
+		fmt.Println("Error marshaling JSON:", err)
 
 		return
 
-	} else {
+	}
+
+	results.push(jsonData)
 
-		logInstance.LogInfo("Router socket created successfully")
+}
+
+/*
+encodeReply encodes a response or event for the PUSH and ROUTER sockets: as a JSON string whose value is the
+JSON message, the format the PUSH socket has always used, so that clients of both sockets decode replies alike.
+*/
+func encodeReply(message string) (string, error) {
+
+	jsonData, err := json.Marshal(message)
+
+	return string(jsonData), err
+
+}
+
+/*
+deliverEvent queues an event for the sender and publishes it.
+*/
+func deliverEvent(event string) {
+
+	queueResult(event)
+
+	publish(event)
+
+}
+
+/*
+publish hands a response or event to the PUB socket, if one is configured, and to the gRPC subscribers.
+*/
+func publish(message string) {
+
+	if publications == nil && !subscribers.active() {
+
+		return
 
 	}
 
-	defer socket.Close()
+	topic := topicOf(message)
 
-	err = socket.Connect(inBoundAddress)
+	if publications != nil {
 
-	if err != nil {
+		publications.publish(topic, message)
+
+	}
+
+	subscribers.deliver(topic, message)
+
+}
+
+/*
+postProcess records the availability of the targeted host, evaluates the thresholds against successful
+polling results and stores the status of
+scheduled requests in the state of their monitor. It does not wait for the probe that confirms a failure to reach
+a host, so a worker is never held by an unreachable host after its request is done.
+*/
+func postProcess(response string) {
+
+	var outcome struct {
+		RequestType string                 `json:"RequestType"`
+		SystemType  string                 `json:"SystemType"`
+		IP          string                 `json:"ip"`
+		URL         string                 `json:"url"`
+		Port        float64                `json:"port"`
+		ServerName  string                 `json:"serverName"`
+		MonitorID   string                 `json:"monitorId"`
+		Status      string                 `json:"status"`
+		Result      map[string]interface{} `json:"result"`
+		Errors      map[string]interface{} `json:"errors"`
+	}
 
-		logInstance.LogError(errors.New("Failed to bind router socket: " + err.Error()))
+	if err := json.Unmarshal([]byte(response), &outcome); err != nil {
 
 		return
 
-	} else {
+	}
+
+	switch outcome.RequestType {
+
+	case RequestTypeDiscovery, RequestTypeProvisioning, RequestTypeInventory, RequestTypeExecute:
+
+		_, rejected := outcome.Errors["deadline_exceeded"]
+
+		_, open := outcome.Errors["circuit_open"]
+
+		// a request rejected by the limiter or the circuit breaker says nothing about the host
+		host, port := limiter.Host(map[string]interface{}{"ip": outcome.IP, "url": outcome.URL}), int(outcome.Port)
+
+		if outcome.IP == "" && port == 0 {
+
+			port = portOfURL(outcome.URL)
+
+		}
 
-		logInstance.LogInfo("Successfully bound router socket")
+		if host != "" && !rejected && !open {
+
+			errorClass, _ := outcome.Errors["error_class"].(string)
+
+			availability.DefaultTracker().RecordPollOutcome(host, outcome.SystemType, port, outcome.Status == "success", errorClass)
+
+		}
 
 	}
 
-	for {
+	if outcome.RequestType == RequestTypeProvisioning && outcome.Status == "success" {
 
-		msg, err := socket.Recv(0)
+		target := alerting.Target{IP: outcome.IP, URL: outcome.URL, Port: int(outcome.Port), ServerName: outcome.ServerName}
 
-		if err != nil {
+		alerting.DefaultEvaluator().Evaluate(target, outcome.SystemType, outcome.Result)
 
-			logInstance.LogError(fmt.Errorf("Worker %d failed to receive message: %v", ID, err))
+	}
 
-			continue
+	if outcome.MonitorID != "" {
+
+		if instance := scheduler.Instance(); instance != nil {
+
+			instance.RecordResult(outcome.MonitorID, outcome.Status)
+
+		}
+
+	}
+
+}
+
+/*
+submitScheduled hands a request produced by the scheduler to the worker pool.
+It returns false without blocking when the queue of scheduled requests is full.
+*/
+func submitScheduled(request string) bool {
+
+	select {
+
+	case scheduledChan <- request:
+
+		return true
+
+	default:
+
+		return false
+
+	}
+
+}
+
+/*
+receiver reads the inbound requests from the single PULL socket and hands them to the worker pool.
+*/
+func receiver() {
+
+	socket, err := zmq4.NewSocket(zmq4.PULL)
+
+	if err != nil {
+
+		logInstance.LogError(errors.New("Failed to create receive socket: " + err.Error()))
+
+		return
+
+	}
+
+	defer socket.Close()
+
+	if hwm := util.LoadConfig().Transport.ReceiveHighWaterMark; hwm > 0 {
+
+		if err := socket.SetRcvhwm(hwm); err != nil {
+
+			logInstance.LogError(fmt.Errorf("Receiver failed to set receive high-water mark: %v", err))
 
 		}
 
-		response := handleRequest(msg)
+	}
+
+	if err := secureClient(socket); err != nil {
+
+		logInstance.LogError(errors.New("Failed to enable CURVE on receive socket: " + err.Error()))
 
-		jsonData, err := json.Marshal(response)
+		return
+
+	}
+
+	err = socket.Connect(inBoundAddress)
+
+	if err != nil {
+
+		logInstance.LogError(errors.New("Failed to connect receive socket: " + err.Error()))
+
+		return
+
+	}
+
+	logInstance.LogInfo("Receiver is ready and connected to PULL socket")
+
+	for {
+
+		msg, err := socket.Recv(0)
 
 		if err != nil {
 
-			fmt.Println("Error marshaling JSON:", err)
+			logInstance.LogError(fmt.Errorf("Failed to receive message: %v", err))
 
-			return
+			continue
 
 		}
 
-		resultChan <- string(jsonData)
+		pool.submit(msg, nil)
 
 	}
 
@@ -209,6 +722,24 @@ func sender() {
 
 	defer socket.Close()
 
+	if hwm := util.LoadConfig().Transport.SendHighWaterMark; hwm > 0 {
+
+		if err := socket.SetSndhwm(hwm); err != nil {
+
+			logInstance.LogError(fmt.Errorf("Sender failed to set send high-water mark: %v", err))
+
+		}
+
+	}
+
+	if err := secureServer(socket); err != nil {
+
+		logInstance.LogError(errors.New("Sender failed to enable CURVE on PUSH socket: " + err.Error()))
+
+		return
+
+	}
+
 	err = socket.Bind(outBoundAddress)
 
 	if err != nil {
@@ -221,10 +752,12 @@ func sender() {
 
 	logInstance.LogInfo("Sender is ready and bound to PUSH socket")
 
-	for msg := range resultChan {
+	for msg := range results.messages {
 
 		_, err := socket.Send(msg, 0)
 
+		results.delivered(err)
+
 		if err != nil {
 
 			logInstance.LogError(fmt.Errorf("Failed to send message: %v", err))
@@ -236,17 +769,23 @@ func sender() {
 }
 
 /*
-StartZMQServer initializes a ZeroMQ REP socket server that listens for incoming requests on port 5555.
-It processes each request using the handleRequest function and sends back the response.
+StartZMQServer starts the engine: the sender bound to the outbound PUSH socket on port 5556, the scheduler,
+the worker pool and the receiver that reads requests from the inbound PULL socket on port 5555.
 
 The function performs the following steps:
-1. Creates a new ZeroMQ REP socket.
-2. Binds the socket to tcp://*:5555.
-3. Enters an infinite loop to:
+1. Enables CURVE security on all sockets when the security configuration asks for it, and refuses to start
+if the keys are invalid.
+2. Creates the result queue and starts the sender, and the publisher on the publish address of the transport
+configuration, if any.
+3. Starts the scheduler with the monitors of the monitor store.
+4. Starts the minimum number of workers of the worker pool.
+5. Starts the ROUTER socket on the router address of the transport configuration, if any, for clients that
+expect the response to their own requests.
+6. Starts the gRPC API on the address of the grpc configuration, if any.
+7. Enters an infinite loop to:
   - Receive incoming requests.
   - Log any errors encountered while receiving requests.
-  - Process the received request using handleRequest.
-  - Send the response back to the client using sendResponse.
+  - Hand the request to the worker pool, which processes it using handleRequest and queues the response.
 
 Logs any errors encountered during the process.
 */
@@ -256,16 +795,84 @@ func StartZMQServer() {
 
 	logInstance.LogInfo("worker started")
 
+	if err := startSecurity(util.LoadConfig().Security); err != nil {
+
+		logInstance.LogError(fmt.Errorf("failed to enable CURVE security, refusing to start: %v", err))
+
+		return
+
+	}
+
+	transport := util.LoadConfig().Transport
+
+	results = newResultQueue(transport.ResultQueueSize, transport.OverflowPolicy, transport.SpillPath)
+
+	scheduledSize := transport.ScheduledQueueSize
+
+	if scheduledSize <= 0 {
+
+		scheduledSize = DefaultQueueSize
+
+	}
+
+	scheduledChan = make(chan string, scheduledSize)
+
+	util.RegisterHealthReporter("resultQueue", func() interface{} { return results.Stats() })
+
 	go sender()
 
-	for i := 0; i < workerCount; i++ {
+	if transport.PublishAddress != "" {
+
+		publications = newPublisher(transport.PublishAddress, transport.ResultQueueSize)
+
+		util.RegisterHealthReporter("publisher", func() interface{} { return publications.Stats() })
+
+		go publications.serve()
+
+	}
+
+	util.SetEventSink(deliverEvent)
+
+	storePath := util.LoadConfig().MonitorStore
+
+	if storePath == "" {
+
+		storePath = scheduler.DefaultStorePath
+
+	}
+
+	scheduler.Start(submitScheduled, storePath)
+
+	pool = newWorkerPool(util.LoadConfig().WorkerPool, transport.RequestQueueSize, scheduledChan, processRequest)
+
+	util.RegisterHealthReporter("workerPool", func() interface{} { return pool.Stats() })
+
+	util.RegisterHealthReporter("limits", func() interface{} { return limiter.Default().Stats() })
+
+	util.RegisterHealthReporter("circuitBreaker", func() interface{} { return breaker.Default().Stats() })
+
+	pool.start()
+
+	if transport.RouterAddress != "" {
+
+		router := newRouterServer(transport.RouterAddress, transport.RequestQueueSize)
+
+		util.RegisterHealthReporter("router", func() interface{} { return router.Stats() })
+
+		go router.serve()
+
+	}
+
+	if grpcConfig := util.LoadConfig().GRPC; grpcConfig.Address != "" {
+
+		service := &engineService{address: grpcConfig.Address}
 
-		wg.Add(1)
+		util.RegisterHealthReporter("grpc", func() interface{} { return service.Stats() })
 
-		go worker(i+1, &wg)
+		go serveGRPC(service, grpcConfig)
 
 	}
 
-	wg.Wait()
+	receiver()
 
 }
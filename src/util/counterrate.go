@@ -0,0 +1,147 @@
+package util
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// bootTimeTolerance absorbs the rounding of uptimes and clock drift when the boot times of two polls are compared.
+const bootTimeTolerance = 60 * time.Second
+
+type counterSample struct {
+	value float64
+	time  time.Time
+}
+
+/*
+RateTracker keeps the previous sample of counter metrics per host so that deltas and per-second rates
+can be computed between polls. Samples of a host are discarded when its boot time changes, i.e. after a reboot.
+*/
+type RateTracker struct {
+	mutex     sync.Mutex
+	samples   map[string]map[string]counterSample
+	bootTimes map[string]time.Time
+}
+
+/*
+CounterRate is the change of a counter between two polls.
+Reset is set, without a rate, when the counter went back to a lower value that is not a wrap.
+*/
+type CounterRate struct {
+	Delta   float64
+	PerSec  float64
+	Wrapped bool
+	Reset   bool
+}
+
+/*
+NewRateTracker returns an empty RateTracker.
+*/
+func NewRateTracker() *RateTracker {
+
+	return &RateTracker{
+
+		samples: make(map[string]map[string]counterSample),
+
+		bootTimes: make(map[string]time.Time),
+	}
+
+}
+
+/*
+ObserveUptime records the uptime in seconds of a host polled at now and discards its previous samples if the host
+rebooted. A reboot is detected by the boot time, now minus the uptime, moving by more than bootTimeTolerance,
+so that it is also detected when the host has been up longer than it was at the previous poll.
+
+Returns:
+- true if a reboot was detected.
+*/
+func (t *RateTracker) ObserveUptime(host string, uptime float64, now time.Time) bool {
+
+	t.mutex.Lock()
+
+	defer t.mutex.Unlock()
+
+	bootTime := now.Add(-time.Duration(uptime * float64(time.Second)))
+
+	previous, exists := t.bootTimes[host]
+
+	t.bootTimes[host] = bootTime
+
+	if difference := bootTime.Sub(previous); exists && (difference > bootTimeTolerance || difference < -bootTimeTolerance) {
+
+		delete(t.samples, host)
+
+		return true
+
+	}
+
+	return false
+
+}
+
+/*
+Observe records a counter sample and computes its change since the previous sample of the same host and metric.
+A counter lower than the previous sample is treated as a wrap when both values fit in 32 bits and the wrapped delta
+is less than half the 32-bit range, and as a reset of the counter otherwise: 64-bit counters do not wrap between
+polls, and a large wrapped delta is more likely a restart of the service that owns the counter.
+
+Returns:
+- The CounterRate, and true if a previous sample was available to compute it and the counter was not reset.
+*/
+func (t *RateTracker) Observe(host string, metric string, value float64, now time.Time) (CounterRate, bool) {
+
+	t.mutex.Lock()
+
+	defer t.mutex.Unlock()
+
+	hostSamples, exists := t.samples[host]
+
+	if !exists {
+
+		hostSamples = make(map[string]counterSample)
+
+		t.samples[host] = hostSamples
+
+	}
+
+	previous, exists := hostSamples[metric]
+
+	hostSamples[metric] = counterSample{value: value, time: now}
+
+	if !exists {
+
+		return CounterRate{}, false
+
+	}
+
+	elapsed := now.Sub(previous.time).Seconds()
+
+	if elapsed <= 0 {
+
+		return CounterRate{}, false
+
+	}
+
+	rate := CounterRate{Delta: value - previous.value}
+
+	if rate.Delta < 0 {
+
+		wrapped := rate.Delta + math.MaxUint32 + 1
+
+		if previous.value > math.MaxUint32 || value > math.MaxUint32 || wrapped > math.MaxUint32/2 {
+
+			return CounterRate{Reset: true}, false
+
+		}
+
+		rate.Delta, rate.Wrapped = wrapped, true
+
+	}
+
+	rate.PerSec = rate.Delta / elapsed
+
+	return rate, true
+
+}
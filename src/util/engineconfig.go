@@ -0,0 +1,219 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	DefaultConfigFilePath = "src/config/config.json"
+	ConfigFilePathEnv     = "PLUGIN_ENGINE_CONFIG"
+)
+
+/*
+ScriptTemplate is an allow-listed script that may be run through an execute request.
+Placeholders of the form {{name}} in Script are bound to the request parameters listed in Parameters,
+each passed as a quoted string literal.
+*/
+type ScriptTemplate struct {
+	SystemType  string   `json:"systemType"`
+	Description string   `json:"description"`
+	Script      string   `json:"script"`
+	Parameters  []string `json:"parameters"`
+}
+
+/*
+CustomMetric is a site-specific metric collected by the polling plugin of SystemType alongside the built-in set.
+Script is a PowerShell snippet (or shell snippet for other plugins) whose output is converted according to
+ResultType: "int", "float", "bool", "json" or "string".
+*/
+type CustomMetric struct {
+	SystemType string `json:"systemType"`
+	Metric     string `json:"metric"`
+	Script     string `json:"script"`
+	ResultType string `json:"resultType"`
+	Unit       string `json:"unit"`
+}
+
+/*
+Threshold is a limit evaluated against a metric of every successful polling result.
+Comparison is one of >, >=, <, <=, == or != and defaults to >. An alert is raised after Consecutive breaches
+(default 1) and cleared once the value is back past the limit by Hysteresis.
+An empty SystemType applies the threshold to all system types.
+*/
+type Threshold struct {
+	Metric      string   `json:"metric"`
+	SystemType  string   `json:"systemType"`
+	Comparison  string   `json:"comparison"`
+	Warning     *float64 `json:"warning"`
+	Critical    *float64 `json:"critical"`
+	Consecutive int      `json:"consecutive"`
+	Hysteresis  float64  `json:"hysteresis"`
+}
+
+/*
+TransportConfig holds the settings of the sockets and internal queues between the workers and the sender.
+OverflowPolicy decides what happens when the result queue is full: "block" (default) makes the workers wait,
+"drop-oldest" discards the oldest queued result and "spill" appends results to SpillPath until the queue drains.
+High-water marks of 0 keep the ZeroMQ default.
+RouterAddress, when set, is bound by a ROUTER socket that serves requests synchronously: each response goes back
+to the client that sent the request instead of the outbound PUSH socket. Both sockets send a response as a JSON
+string whose value is the JSON response, e.g. "{\n  \"status\": \"success\", ...}", so clients decode it twice.
+PublishAddress, when set, is bound by a PUB socket that also publishes every response and event, as the JSON
+message itself, on topics such as "metrics.<SystemType>.<ip>.", which end with a dot so that a subscription can
+select a single host.
+*/
+type TransportConfig struct {
+	RequestQueueSize     int    `json:"requestQueueSize"`
+	ResultQueueSize      int    `json:"resultQueueSize"`
+	ScheduledQueueSize   int    `json:"scheduledQueueSize"`
+	SendHighWaterMark    int    `json:"sendHighWaterMark"`
+	ReceiveHighWaterMark int    `json:"receiveHighWaterMark"`
+	OverflowPolicy       string `json:"overflowPolicy"`
+	SpillPath            string `json:"spillPath"`
+	RouterAddress        string `json:"routerAddress"`
+	PublishAddress       string `json:"publishAddress"`
+}
+
+/*
+WorkerPoolConfig bounds the number of workers. The pool grows from MinWorkers up to MaxWorkers while queued
+requests would wait longer than TargetWaitMs, and workers above MinWorkers stop after IdleTimeoutSeconds idle.
+ReservedWorkers maps a priority lane (control, discovery, polling or bulk) to the number of workers that requests
+of lower lanes must leave free for it; by default one worker is kept for control and one for discovery requests.
+*/
+type WorkerPoolConfig struct {
+	MinWorkers         int            `json:"minWorkers"`
+	MaxWorkers         int            `json:"maxWorkers"`
+	IdleTimeoutSeconds int            `json:"idleTimeoutSeconds"`
+	TargetWaitMs       int            `json:"targetWaitMs"`
+	ReservedWorkers    map[string]int `json:"reservedWorkers"`
+}
+
+/*
+RateLimit is a token bucket: Rate requests per second on average, with bursts of up to Burst requests (default 1).
+*/
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+/*
+LimitsConfig bounds the load the engine puts on its targets. MaxPerHost caps the concurrent requests to one host,
+the ip of a request or the host of its url (default 2), GlobalRate and PluginRates (by SystemType) cap the request rate, and DeadlineMs is how long a request
+may wait for its limits before failing (default 60000), unless the request sets deadlineMs.
+*/
+type LimitsConfig struct {
+	MaxPerHost  int                  `json:"maxPerHost"`
+	GlobalRate  RateLimit            `json:"globalRate"`
+	PluginRates map[string]RateLimit `json:"pluginRates"`
+	DeadlineMs  int                  `json:"deadlineMs"`
+}
+
+/*
+CircuitBreakerConfig controls the circuit breaker, which keeps a circuit per host and SystemType (and port, when the
+request sets one). After FailureThreshold consecutive requests failing with a class in FailOn (default 5), each
+counted once whatever its retries, requests to the service fail at once for OpenSeconds (default 60); then a single probe
+request is let through, closing the circuit if it succeeds and opening it again if it fails.
+*/
+type CircuitBreakerConfig struct {
+	FailureThreshold int      `json:"failureThreshold"`
+	OpenSeconds      int      `json:"openSeconds"`
+	FailOn           []string `json:"failOn"`
+}
+
+/*
+SecurityConfig enables CurveZMQ encryption and authentication on the sockets of the engine. Keys are Z85-encoded,
+as printed by the keygen command. The engine key pair serves the sockets the engine binds and identifies the engine
+on the inbound socket it connects to, which also requires ControllerPublicKey, the public key of the controller.
+Clients of the bound sockets are accepted only if their public key is in AllowedClientKeys; "*" accepts any client.
+PublicKey may be left empty, as it is derived from SecretKey.
+*/
+type SecurityConfig struct {
+	Curve               bool     `json:"curve"`
+	PublicKey           string   `json:"publicKey"`
+	SecretKey           string   `json:"secretKey"`
+	ControllerPublicKey string   `json:"controllerPublicKey"`
+	AllowedClientKeys   []string `json:"allowedClientKeys"`
+}
+
+/*
+GRPCConfig enables the gRPC API on Address, e.g. "127.0.0.1:50051". The API is served over TLS with the
+certificate and key of CertFile and KeyFile when both are set, and in cleartext otherwise, which is refused when
+CURVE security is enabled. Clients must authenticate, with a certificate signed by a CA of ClientCAFile (TLS only),
+or with one of Tokens in an "authorization: Bearer <token>" metadata entry; the API does not start without either.
+*/
+type GRPCConfig struct {
+	Address      string   `json:"address"`
+	CertFile     string   `json:"certFile"`
+	KeyFile      string   `json:"keyFile"`
+	ClientCAFile string   `json:"clientCAFile"`
+	Tokens       []string `json:"tokens"`
+}
+
+/*
+EngineConfig holds the settings loaded from the engine configuration file.
+*/
+type EngineConfig struct {
+	LogFilePath     string                    `json:"logFilePath"`
+	ScriptTemplates map[string]ScriptTemplate `json:"scriptTemplates"`
+	CustomMetrics   []CustomMetric            `json:"customMetrics"`
+	MonitorStore    string                    `json:"monitorStore"`
+	Thresholds      []Threshold               `json:"thresholds"`
+	Transport       TransportConfig           `json:"transport"`
+	WorkerPool      WorkerPoolConfig          `json:"workerPool"`
+	Limits          LimitsConfig              `json:"limits"`
+	Retries         map[string]RetryPolicy    `json:"retries"`
+	CircuitBreaker  CircuitBreakerConfig      `json:"circuitBreaker"`
+	Security        SecurityConfig            `json:"security"`
+	GRPC            GRPCConfig                `json:"grpc"`
+}
+
+var (
+	configOnce     sync.Once
+	configInstance *EngineConfig
+)
+
+/*
+LoadConfig reads the engine configuration once and returns it.
+The file path is taken from the PLUGIN_ENGINE_CONFIG environment variable, falling back to src/config/config.json.
+A missing or invalid file is logged and an empty configuration is used instead.
+*/
+func LoadConfig() *EngineConfig {
+
+	configOnce.Do(func() {
+
+		configInstance = &EngineConfig{}
+
+		path := os.Getenv(ConfigFilePathEnv)
+
+		if path == "" {
+
+			path = DefaultConfigFilePath
+
+		}
+
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+
+			InitializeLogger().LogWarning(fmt.Sprintf("Failed to read config file %s, using defaults: %v", path, err))
+
+			return
+
+		}
+
+		if err := json.Unmarshal(data, configInstance); err != nil {
+
+			InitializeLogger().LogError(fmt.Errorf("failed to parse config file %s: %v", path, err))
+
+			configInstance = &EngineConfig{}
+
+		}
+
+	})
+
+	return configInstance
+
+}
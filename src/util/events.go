@@ -0,0 +1,73 @@
+package util
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+/*
+Event types published on the outbound stream.
+*/
+const (
+	EventTypeAlert = "alert"
+	EventTypeClear = "clear"
+
+	EventTypeStateChange = "state_change"
+
+	EventTypeCircuitChange = "circuit_change"
+)
+
+var (
+	eventSinkMutex sync.RWMutex
+	eventSink      func(string)
+)
+
+/*
+SetEventSink registers the function that delivers published events, e.g. to the outbound socket.
+*/
+func SetEventSink(sink func(string)) {
+
+	eventSinkMutex.Lock()
+
+	defer eventSinkMutex.Unlock()
+
+	eventSink = sink
+
+}
+
+/*
+PublishEvent stamps an event with its type and time and hands it to the registered sink as JSON.
+Events are dropped when no sink is registered.
+*/
+func PublishEvent(eventType string, event map[string]interface{}) {
+
+	eventSinkMutex.RLock()
+
+	sink := eventSink
+
+	eventSinkMutex.RUnlock()
+
+	if sink == nil {
+
+		return
+
+	}
+
+	event["event"] = eventType
+
+	event["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+
+	data, err := json.Marshal(event)
+
+	if err != nil {
+
+		InitializeLogger().LogError(err)
+
+		return
+
+	}
+
+	sink(string(data))
+
+}
@@ -1,15 +1,34 @@
 package util
 
 import (
-"encoding/json"
+	"encoding/json"
+	"sync"
 )
 
-
 type HealthCheckResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Status  string                 `json:"status"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
 }
 
+var (
+	healthReportersMutex sync.RWMutex
+	healthReporters      = make(map[string]func() interface{})
+)
+
+/*
+RegisterHealthReporter adds a section to the details of the health response.
+The report function is called on every health request and must be safe for concurrent use.
+*/
+func RegisterHealthReporter(name string, report func() interface{}) {
+
+	healthReportersMutex.Lock()
+
+	defer healthReportersMutex.Unlock()
+
+	healthReporters[name] = report
+
+}
 
 func HandleHealthCheck(responseData map[string]interface{}) string {
 
@@ -18,6 +37,22 @@ func HandleHealthCheck(responseData map[string]interface{}) string {
 		Message: "Service is running smoothly",
 	}
 
+	healthReportersMutex.RLock()
+
+	if len(healthReporters) > 0 {
+
+		response.Details = make(map[string]interface{}, len(healthReporters))
+
+		for name, report := range healthReporters {
+
+			response.Details[name] = report()
+
+		}
+
+	}
+
+	healthReportersMutex.RUnlock()
+
 	responseBytes, err := json.Marshal(response)
 
 	if err != nil {
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+)
+
+/*
+powerShellQuotes are the characters PowerShell accepts as single quotes in a string literal.
+Each of them has to be doubled to appear literally inside a single-quoted string.
+*/
+const powerShellQuotes = "'‘’‚‛"
+
+/*
+EncodePowerShellCommand encodes a script for powershell -EncodedCommand: UTF-16LE, then base64.
+The script is passed to PowerShell verbatim, so it needs no escaping for the command line.
+*/
+func EncodePowerShellCommand(script string) string {
+
+	units := utf16.Encode([]rune(script))
+
+	encoded := make([]byte, len(units)*2)
+
+	for i, unit := range units {
+
+		binary.LittleEndian.PutUint16(encoded[i*2:], unit)
+
+	}
+
+	return base64.StdEncoding.EncodeToString(encoded)
+
+}
+
+/*
+MaxCommandLineLength is the longest command line cmd.exe accepts, which the WinRM cmd shell enforces.
+*/
+const MaxCommandLineLength = 8191
+
+/*
+powerShellBootstrap reads a script sent by PowerShellInvocation from stdin and runs it. The script travels
+base64-encoded so that it reaches PowerShell intact whatever the console code page.
+Progress output is disabled so it does not end up in stderr.
+*/
+const powerShellBootstrap = "$ProgressPreference = 'SilentlyContinue';" +
+	"$script = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String([Console]::In.ReadToEnd()));" +
+	"& ([ScriptBlock]::Create($script))"
+
+/*
+PowerShellInvocation returns how to run script through powershell.exe over WinRM.
+Scripts are too long for the command line, which is limited to MaxCommandLineLength characters, so the command
+line only holds a short bootstrap that reads the script from stdin.
+
+Returns:
+- The command line, whose length does not depend on script.
+- The stdin of the command: script, UTF-8 and base64 encoded.
+*/
+func PowerShellInvocation(script string) (string, string) {
+
+	commandLine := "powershell -ExecutionPolicy Bypass -NoProfile -NonInteractive -EncodedCommand " + EncodePowerShellCommand(powerShellBootstrap)
+
+	return commandLine, base64.StdEncoding.EncodeToString([]byte(script))
+
+}
+
+/*
+QuotePowerShellString returns value as a single-quoted PowerShell string literal.
+Single-quoted strings are not expanded, so the value cannot inject variables, subexpressions or commands.
+*/
+func QuotePowerShellString(value string) string {
+
+	var quoted strings.Builder
+
+	quoted.WriteByte('\'')
+
+	for _, r := range value {
+
+		if strings.ContainsRune(powerShellQuotes, r) {
+
+			quoted.WriteRune(r)
+
+		}
+
+		quoted.WriteRune(r)
+
+	}
+
+	quoted.WriteByte('\'')
+
+	return quoted.String()
+
+}
+
+/*
+BindPowerShellParameters replaces the {{name}} placeholders in script with the matching values,
+each quoted with QuotePowerShellString.
+
+Returns:
+- The bound script.
+- An error if the script references a placeholder without a value.
+*/
+func BindPowerShellParameters(script string, values map[string]string) (string, error) {
+
+	return bindPlaceholders(script, values, QuotePowerShellString)
+
+}
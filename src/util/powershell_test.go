@@ -0,0 +1,164 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+/*
+readSingleQuoted reads a PowerShell single-quoted string literal at the start of script the way the PowerShell
+tokenizer does: any of the single quote characters opens and closes it, and two in a row stand for one.
+It returns the value of the literal and the rest of the script after it.
+*/
+func readSingleQuoted(t *testing.T, script string) (string, string) {
+
+	t.Helper()
+
+	runes := []rune(script)
+
+	if len(runes) == 0 || !strings.ContainsRune(powerShellQuotes, runes[0]) {
+
+		t.Fatalf("%q does not start with a single-quoted literal", script)
+
+	}
+
+	var value strings.Builder
+
+	for i := 1; i < len(runes); i++ {
+
+		if !strings.ContainsRune(powerShellQuotes, runes[i]) {
+
+			value.WriteRune(runes[i])
+
+			continue
+
+		}
+
+		if i+1 < len(runes) && strings.ContainsRune(powerShellQuotes, runes[i+1]) {
+
+			value.WriteRune(runes[i])
+
+			i++
+
+			continue
+
+		}
+
+		return value.String(), string(runes[i+1:])
+
+	}
+
+	t.Fatalf("unterminated literal in %q", script)
+
+	return "", ""
+
+}
+
+var hostileValues = []struct {
+	name  string
+	value string
+}{
+	{"plain", "C:\\Windows\\System32"},
+	{"empty", ""},
+	{"single quote", "it's"},
+	{"closing quote and command", "x'; Remove-Item C:\\ -Recurse; '"},
+	{"trailing quote", "x'"},
+	{"only quotes", "''''"},
+	{"left single quotation mark", "x\u2018; Stop-Computer; \u2018"},
+	{"right single quotation mark", "x\u2019; Stop-Computer; \u2019"},
+	{"single low-9 quotation mark", "x\u201a; Stop-Computer; \u201a"},
+	{"single high-reversed-9 quotation mark", "x\u201b; Stop-Computer; \u201b"},
+	{"mixed quotes", "'\u2018\u2019\u201a\u201b'"},
+	{"subexpression", "$(Stop-Computer)"},
+	{"variable", "$env:USERNAME"},
+	{"backtick escapes", "`'; Stop-Computer; `"},
+	{"trailing backtick", "x`"},
+	{"newlines", "x'\nStop-Computer\r\n'"},
+	{"double quotes", "\"$(Stop-Computer)\""},
+}
+
+func TestQuotePowerShellString(t *testing.T) {
+
+	for _, test := range hostileValues {
+
+		quoted := QuotePowerShellString(test.value)
+
+		value, rest := readSingleQuoted(t, quoted)
+
+		if value != test.value || rest != "" {
+
+			t.Errorf("%s: %q reads as %q followed by %q, want the whole literal to be %q", test.name, quoted, value, rest, test.value)
+
+		}
+
+	}
+
+}
+
+func TestBindPowerShellParameters(t *testing.T) {
+
+	for _, test := range hostileValues {
+
+		bound, err := BindPowerShellParameters("Get-Item -Path {{ path }} | Out-String", map[string]string{"path": test.value})
+
+		if err != nil {
+
+			t.Errorf("%s: %v", test.name, err)
+
+			continue
+
+		}
+
+		literal, found := strings.CutPrefix(bound, "Get-Item -Path ")
+
+		if !found {
+
+			t.Errorf("%s: bound script %q changed the text before the placeholder", test.name, bound)
+
+			continue
+
+		}
+
+		value, rest := readSingleQuoted(t, literal)
+
+		if value != test.value || rest != " | Out-String" {
+
+			t.Errorf("%s: bound script %q binds %q followed by %q, want %q followed by %q", test.name, bound, value, rest, test.value, " | Out-String")
+
+		}
+
+	}
+
+}
+
+func TestBindPowerShellParametersMissingValues(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		script string
+		values map[string]string
+	}{
+		{"undeclared placeholder", "Get-Service -Name {{service}}", map[string]string{"name": "spooler"}},
+		{"no values", "Get-Service -Name {{service}}", nil},
+		{"second placeholder missing", "Get-Item {{path}}; Get-Service {{service}}", map[string]string{"path": "C:\\"}},
+	}
+
+	for _, test := range tests {
+
+		if bound, err := BindPowerShellParameters(test.script, test.values); err == nil {
+
+			t.Errorf("%s: bound %q, want an error", test.name, bound)
+
+		}
+
+	}
+
+	bound, err := BindPowerShellParameters("Get-Date", nil)
+
+	if err != nil || bound != "Get-Date" {
+
+		t.Errorf("script without placeholders: %q, %v", bound, err)
+
+	}
+
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"net/url"
+	"strings"
+)
+
+// RedactedValue replaces the credentials removed by RedactCredentials.
+const RedactedValue = "******"
+
+/*
+credentialKeys are the lower-cased names of the request fields and HTTP headers that hold credentials.
+*/
+var credentialKeys = map[string]bool{
+	"password":            true,
+	"passphrase":          true,
+	"secret":              true,
+	"token":               true,
+	"apikey":              true,
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"x-api-key":           true,
+}
+
+/*
+RedactCredentials replaces the credentials of a request or response, such as password, the Authorization header
+and the password in the url of an HTTP check, so that they are not echoed to the consumers of the response. Nested maps are redacted too,
+except result, which holds what the target reported.
+*/
+func RedactCredentials(data map[string]interface{}) {
+
+	for key, value := range data {
+
+		if credentialKeys[strings.ToLower(key)] {
+
+			if value != nil && value != "" {
+
+				data[key] = RedactedValue
+
+			}
+
+			continue
+
+		}
+
+		if text, ok := value.(string); ok && key == "url" {
+
+			if parsed, err := url.Parse(text); err == nil && parsed.User != nil {
+
+				if _, set := parsed.User.Password(); set {
+
+					parsed.User = url.UserPassword(parsed.User.Username(), RedactedValue)
+
+					data[key] = parsed.String()
+
+				}
+
+			}
+
+			continue
+
+		}
+
+		if nested, ok := value.(map[string]interface{}); ok && key != "result" {
+
+			RedactCredentials(nested)
+
+		}
+
+	}
+
+}
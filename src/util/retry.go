@@ -0,0 +1,296 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+/*
+Error classes reported as error_class by the plugins and matched by the retry policies.
+ErrorClassLocal is a failure of the engine itself, such as missing privileges to open a socket, which says nothing
+about the target.
+*/
+const (
+	ErrorClassTimeout           = "timeout"
+	ErrorClassConnectionRefused = "connection_refused"
+	ErrorClassConnectionReset   = "connection_reset"
+	ErrorClassServerError       = "server_error"
+	ErrorClassAuth              = "auth"
+	ErrorClassUnreachable       = "unreachable"
+	ErrorClassOther             = "other"
+	ErrorClassLocal             = "local"
+)
+
+const DefaultRetryPolicyName = "default"
+
+/*
+IsTransportError reports whether errorClass is a failure to reach the target, as opposed to an answer from it
+such as an authentication or server error.
+*/
+func IsTransportError(errorClass string) bool {
+
+	switch errorClass {
+
+	case ErrorClassTimeout, ErrorClassConnectionRefused, ErrorClassConnectionReset, ErrorClassUnreachable:
+
+		return true
+
+	}
+
+	return false
+
+}
+
+// winRMStatus matches the HTTP status of the errors of the WinRM client, e.g. "http error 503: ...".
+var winRMStatus = regexp.MustCompile(`http (?:response )?error:? (\d{3})`)
+
+/*
+RetryPolicy describes how a failed request is retried. MaxAttempts counts the first attempt, so 1 disables
+retries. The delay before attempt n+1 is InitialBackoffMs * Multiplier^(n-1), capped at MaxBackoffMs and varied
+by up to Jitter (a fraction) in both directions. Only failures of the classes in RetryOn are retried, and
+authentication failures never are.
+*/
+type RetryPolicy struct {
+	MaxAttempts      int      `json:"maxAttempts"`
+	InitialBackoffMs int      `json:"initialBackoffMs"`
+	MaxBackoffMs     int      `json:"maxBackoffMs"`
+	Multiplier       float64  `json:"multiplier"`
+	Jitter           float64  `json:"jitter"`
+	RetryOn          []string `json:"retryOn"`
+}
+
+/*
+DefaultRetryPolicy retries timeouts, refused and reset connections and server errors twice.
+*/
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:      3,
+	InitialBackoffMs: 500,
+	MaxBackoffMs:     5000,
+	Multiplier:       2,
+	Jitter:           0.2,
+	RetryOn:          []string{ErrorClassTimeout, ErrorClassConnectionRefused, ErrorClassConnectionReset, ErrorClassServerError},
+}
+
+/*
+RetryPolicyFor returns the retry policy of a system type: its entry in the retries section of the configuration,
+with the fields it leaves unset (or zero) taken from the entry named default, and those left unset there from
+DefaultRetryPolicy. An empty retryOn list is kept, so it disables the retries.
+*/
+func RetryPolicyFor(systemType string) RetryPolicy {
+
+	retries := LoadConfig().Retries
+
+	policy := DefaultRetryPolicy
+
+	if fallback, ok := retries[DefaultRetryPolicyName]; ok {
+
+		policy = fallback.merge(policy)
+
+	}
+
+	if own, ok := retries[systemType]; ok {
+
+		policy = own.merge(policy)
+
+	}
+
+	return policy
+
+}
+
+/*
+merge returns p with its unset fields taken from fallback.
+*/
+func (p RetryPolicy) merge(fallback RetryPolicy) RetryPolicy {
+
+	if p.MaxAttempts <= 0 {
+
+		p.MaxAttempts = fallback.MaxAttempts
+
+	}
+
+	if p.InitialBackoffMs <= 0 {
+
+		p.InitialBackoffMs = fallback.InitialBackoffMs
+
+	}
+
+	if p.MaxBackoffMs <= 0 {
+
+		p.MaxBackoffMs = fallback.MaxBackoffMs
+
+	}
+
+	if p.Multiplier <= 0 {
+
+		p.Multiplier = fallback.Multiplier
+
+	}
+
+	if p.Jitter <= 0 {
+
+		p.Jitter = fallback.Jitter
+
+	}
+
+	if p.RetryOn == nil {
+
+		p.RetryOn = fallback.RetryOn
+
+	}
+
+	return p
+
+}
+
+/*
+Retries reports whether a failure of errorClass may be retried.
+*/
+func (p RetryPolicy) Retries(errorClass string) bool {
+
+	if errorClass == ErrorClassAuth {
+
+		return false
+
+	}
+
+	for _, class := range p.RetryOn {
+
+		if class == errorClass {
+
+			return true
+
+		}
+
+	}
+
+	return false
+
+}
+
+/*
+Backoff returns the delay before the attempt that follows attempt.
+*/
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+
+	multiplier := p.Multiplier
+
+	if multiplier < 1 {
+
+		multiplier = 1
+
+	}
+
+	delay := float64(p.InitialBackoffMs) * math.Pow(multiplier, float64(attempt-1))
+
+	if p.MaxBackoffMs > 0 && delay > float64(p.MaxBackoffMs) {
+
+		delay = float64(p.MaxBackoffMs)
+
+	}
+
+	if p.Jitter > 0 {
+
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+
+	}
+
+	return time.Duration(delay) * time.Millisecond
+
+}
+
+/*
+ClassifyError returns the error class of a failure to reach or query a target, looking through wrapped errors
+such as CommandError and the errors of the WinRM and HTTP clients.
+*/
+func ClassifyError(err error) string {
+
+	if err == nil {
+
+		return ""
+
+	}
+
+	message := strings.ToLower(err.Error())
+
+	if match := winRMStatus.FindStringSubmatch(message); match != nil {
+
+		status, _ := strconv.Atoi(match[1])
+
+		switch {
+
+		case status == 401 || status == 403:
+
+			return ErrorClassAuth
+
+		case status >= 500:
+
+			return ErrorClassServerError
+
+		}
+
+	}
+
+	var netError net.Error
+
+	switch {
+
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+
+		return ErrorClassTimeout
+
+	case errors.As(err, &netError) && netError.Timeout():
+
+		return ErrorClassTimeout
+
+	case errors.Is(err, syscall.ECONNREFUSED):
+
+		return ErrorClassConnectionRefused
+
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+
+		return ErrorClassConnectionReset
+
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+
+		return ErrorClassUnreachable
+
+	}
+
+	// errors formatted with %v lose their chain, so fall back to the message
+	switch {
+
+	case strings.Contains(message, "authentication failed"), strings.Contains(message, "access denied"):
+
+		return ErrorClassAuth
+
+	case strings.Contains(message, "timeout"), strings.Contains(message, "deadline exceeded"):
+
+		return ErrorClassTimeout
+
+	case strings.Contains(message, "connection refused"):
+
+		return ErrorClassConnectionRefused
+
+	case strings.Contains(message, "connection reset"), strings.Contains(message, "broken pipe"):
+
+		return ErrorClassConnectionReset
+
+	case strings.Contains(message, "no route to host"), strings.Contains(message, "network is unreachable"):
+
+		return ErrorClassUnreachable
+
+	}
+
+	return ErrorClassOther
+
+}
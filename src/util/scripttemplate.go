@@ -0,0 +1,90 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+/*
+RenderScriptTemplate binds the request parameters into an allow-listed script template.
+
+Parameters:
+- template: The script template from the configuration.
+- parameters: The parameter values supplied with the request.
+- bind: The parameter-binding helper of the target shell, e.g. BindPowerShellParameters.
+
+Returns:
+- The rendered script.
+- An error if a declared parameter is missing or an undeclared parameter is supplied.
+*/
+func RenderScriptTemplate(template ScriptTemplate, parameters map[string]interface{}, bind func(string, map[string]string) (string, error)) (string, error) {
+
+	declared := make(map[string]bool)
+
+	for _, name := range template.Parameters {
+
+		declared[name] = true
+
+		if _, ok := parameters[name]; !ok {
+
+			return "", fmt.Errorf("missing parameter %q", name)
+
+		}
+
+	}
+
+	values := make(map[string]string)
+
+	for name, value := range parameters {
+
+		if !declared[name] {
+
+			return "", fmt.Errorf("parameter %q is not allowed by the template", name)
+
+		}
+
+		values[name] = fmt.Sprint(value)
+
+	}
+
+	return bind(template.Script, values)
+
+}
+
+/*
+bindPlaceholders replaces the {{name}} placeholders in script with the matching values passed through quote.
+*/
+func bindPlaceholders(script string, values map[string]string, quote func(string) string) (string, error) {
+
+	var bindErr error
+
+	bound := templatePlaceholder.ReplaceAllStringFunc(script, func(placeholder string) string {
+
+		name := strings.TrimSpace(placeholder[2 : len(placeholder)-2])
+
+		value, ok := values[name]
+
+		if !ok {
+
+			bindErr = fmt.Errorf("script references undeclared parameter %q", name)
+
+			return placeholder
+
+		}
+
+		return quote(value)
+
+	})
+
+	if bindErr != nil {
+
+		return "", bindErr
+
+	}
+
+	return bound, nil
+
+}
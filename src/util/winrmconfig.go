@@ -1,132 +1,220 @@
-	package util
-
-	import (
-		"bytes"
-		"fmt"
-		"github.com/masterzen/winrm"
-		"time"
-	)
-
-	type Config struct {
-		IP       string
-		Username string
-		Password string
-		Port     int
-		Timeout  time.Duration
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/masterzen/winrm"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	IP       string
+	Username string
+	Password string
+	Port     int
+	Timeout  time.Duration
+}
+
+/*
+InitWinRMClient initializes and returns a new WinRM client.
+
+Parameters:
+- config: Config struct containing IP, Username, Password, and Timeout.
+
+Returns:
+- A WinRM client instance.
+- An error if initialization fails.
+*/
+func InitWinRMClient(config Config) (*winrm.Client, error) {
+
+	port := int(config.Port)
+
+	endpoint := winrm.NewEndpoint(config.IP, port, false, false, nil, nil, nil, config.Timeout)
+
+	client, err := winrm.NewClient(endpoint, config.Username, config.Password)
+
+	if err != nil {
+
+		logInstance.LogError(fmt.Errorf("failed to create WinRM client: %v", err))
+
+		return nil, err
+
 	}
 
+	return client, nil
+
+}
+
+/*
+InitWinRMShell initializes a new WinRM shell session for the provided client.
+
+Parameters:
+- client: A WinRM client instance.
 
-	/*
-	InitWinRMClient initializes and returns a new WinRM client.
+Returns:
+- A WinRM shell instance.
+- An error if shell creation fails.
+*/
+func InitWinRMShell(client *winrm.Client) (*winrm.Shell, error) {
 
-	Parameters:
-	- config: Config struct containing IP, Username, Password, and Timeout.
+	shell, err := client.CreateShell()
 
-	Returns:
-	- A WinRM client instance.
-	- An error if initialization fails.
-	*/
-	func InitWinRMClient(config Config) (*winrm.Client, error) {
+	if err != nil {
 
-		port := int(config.Port)
+		logInstance.LogError(fmt.Errorf("failed to create WinRM shell: %v", err))
 
-		endpoint := winrm.NewEndpoint(config.IP, port, false, false, nil, nil, nil, config.Timeout)
+		return nil, err
 
-		client, err := winrm.NewClient(endpoint, config.Username, config.Password)
+	}
+
+	return shell, nil
 
-		if err != nil {
+}
 
-			logInstance.LogError(fmt.Errorf("failed to create WinRM client: %v", err))
+/*
+CloseWinRMShell closes the provided WinRM shell session.
 
-			return nil, err
+Parameters:
+- shell: The WinRM shell instance to be closed.
+*/
+func CloseWinRMShell(shell *winrm.Shell) {
 
-		}
+	if shell != nil {
 
-		return client, nil
+		shell.Close()
+
+		logInstance.LogInfo("WinRM shell closed")
 
 	}
 
-	/*
-	InitWinRMShell initializes a new WinRM shell session for the provided client.
+}
+
+/*
+Kinds of CommandError.
+*/
+const (
+	CommandErrorNotInitialized = "not_initialized"
+	CommandErrorTransport      = "transport"
+	CommandErrorExitCode       = "exit_code"
+	CommandErrorEmptyOutput    = "empty_output"
+)
 
-	Parameters:
-	- client: A WinRM client instance.
+/*
+CommandError describes why a command run over WinRM did not succeed.
+Kind is one of the CommandError* constants; Err holds the underlying transport error, if any.
+*/
+type CommandError struct {
+	Kind     string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
 
-	Returns:
-	- A WinRM shell instance.
-	- An error if shell creation fails.
-	*/
-	func InitWinRMShell(client *winrm.Client) (*winrm.Shell, error) {
+func (e *CommandError) Error() string {
 
-		shell, err := client.CreateShell()
+	switch e.Kind {
 
-		if err != nil {
+	case CommandErrorNotInitialized:
 
-			logInstance.LogError(fmt.Errorf("failed to create WinRM shell: %v", err))
+		return "WinRM client or shell is not initialized"
 
-			return nil, err
+	case CommandErrorExitCode:
 
-		}
+		return fmt.Sprintf("command failed with exit code %d", e.ExitCode)
 
-		return shell, nil
+	case CommandErrorEmptyOutput:
+
+		return "command returned no output"
+
+	default:
+
+		return fmt.Sprintf("execution error: %v", e.Err)
 
 	}
 
+}
+
+func (e *CommandError) Unwrap() error {
 
+	return e.Err
 
-	/*
-	CloseWinRMShell closes the provided WinRM shell session.
+}
 
-	Parameters:
-	- shell: The WinRM shell instance to be closed.
-	*/
-	func CloseWinRMShell(shell *winrm.Shell) {
+/*
+CommandResult holds the outcome of a command run over WinRM.
+Err is nil only when the command ran and exited with code 0.
+*/
+type CommandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	Err      *CommandError
+}
 
-		if shell != nil {
+/*
+ExecuteCommand executes a PowerShell command over WinRM, sending it over stdin (see PowerShellInvocation), and
+captures its stdout, stderr, exit code and duration.
 
-			shell.Close()
+Parameters:
+- client: A WinRM client instance.
+- shell: The WinRM shell opened for the client.
+- command: The PowerShell command to execute.
 
-			logInstance.LogInfo("WinRM shell closed")
+Returns:
+- A CommandResult. Err is set when the command could not be run or exited with a non-zero code.
+*/
+func ExecuteCommand(client *winrm.Client, shell *winrm.Shell, command string) CommandResult {
 
-		}
+	if client == nil || shell == nil {
+
+		result := CommandResult{ExitCode: -1, Err: &CommandError{Kind: CommandErrorNotInitialized, ExitCode: -1}}
+
+		logInstance.LogError(result.Err)
+
+		return result
 
 	}
 
-	
-	func ExecuteCommand(client *winrm.Client, shell *winrm.Shell, command string) string {
+	var stdout, stderr bytes.Buffer
 
-		if client == nil || shell == nil {
+	started := time.Now()
 
-			logInstance.LogError(fmt.Errorf("WinRM client or shell is not initialized"))
+	commandLine, input := PowerShellInvocation(command)
 
-			return ""
+	exitCode, err := client.RunWithContextWithInput(context.Background(), commandLine, &stdout, &stderr, strings.NewReader(input))
 
-		}
+	result := CommandResult{
 
-		var stdout, stderr bytes.Buffer
+		Stdout: stdout.String(),
 
-		exitCode, err := client.Run(`powershell -ExecutionPolicy Bypass -NoProfile -Command "`+command+`"`, &stdout, &stderr)
+		Stderr: stderr.String(),
 
-		if err != nil {
+		ExitCode: exitCode,
 
-			logInstance.LogError(fmt.Errorf("Execution error: %v",err))
+		Duration: time.Since(started),
+	}
 
-			logInstance.LogError(fmt.Errorf("Stderr: %s", stderr.String()))
+	if err != nil {
 
-			return ""
-		}
+		result.Err = &CommandError{Kind: CommandErrorTransport, ExitCode: exitCode, Stderr: result.Stderr, Err: err}
 
-		if exitCode != 0 {
+	} else if exitCode != 0 {
 
-			logInstance.LogError(fmt.Errorf("Command failed with exit code %d\n", exitCode))
+		result.Err = &CommandError{Kind: CommandErrorExitCode, ExitCode: exitCode, Stderr: result.Stderr}
 
-			logInstance.LogError(fmt.Errorf("Stderr: %s\n", stderr.String()))
+	}
+
+	if result.Err != nil {
 
-			return ""
-		}
+		logInstance.LogError(result.Err)
 
-		output := stdout.String()
+		logInstance.LogError(fmt.Errorf("Stderr: %s", result.Stderr))
 
-		return output
 	}
 
+	return result
+
+}